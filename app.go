@@ -163,8 +163,15 @@ func (a *App) UpdateConfigData(updateData map[string]any) map[string]any {
 	}
 	// 避免注入风险
 	validColumns := map[string]bool{
-		"sharedDir":       true,
-		"tokenExpiryTime": true,
+		"sharedDir":          true,
+		"tokenExpiryTime":    true,
+		"trashRetentionDays": true,
+		"maxFileVersions":    true,
+		// 传输限速（KB/s），保存后立即生效
+		"uploadRateLimit":       true,
+		"downloadRateLimit":     true,
+		"userUploadRateLimit":   true,
+		"userDownloadRateLimit": true,
 	}
 	updateFields := []string{}
 	updateValues := []any{}
@@ -211,7 +218,10 @@ func (a *App) ToolsParseToken(token string) (*server.UserToken, error) {
 	return tok, err
 }
 
-func (a *App) ToolsScanNetwork(ipAddr string, subnetMask string) (tools.ScanNetworkData, error) {
+func (a *App) ToolsScanNetwork(token string, ipAddr string, subnetMask string) (tools.ScanNetworkData, error) {
+	if err := server.CheckScanNetworkPermission(token); err != nil {
+		return tools.ScanNetworkData{}, err
+	}
 	if ipAddr == "" || subnetMask == "" {
 		return tools.ScanNetworkData{}, fmt.Errorf("ip地址或子网掩码不能为空")
 	}
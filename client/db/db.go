@@ -55,9 +55,15 @@ func InitDB(dbPath string) (SqlliteDB, error) {
 	)`); err != nil {
 		return sdb, fmt.Errorf("初始化用户表结构失败: %v", err)
 	}
-	// 默认添加超级管理员999账户
+	if err := AddColumnIfNotExists(db, "users", "mustChangePwd", `INTEGER NOT NULL DEFAULT 0`); err != nil {
+		return sdb, fmt.Errorf("升级用户表结构失败: %v", err)
+	}
+	// 默认添加超级管理员999账户，默认密码随后迁移为哈希，首次登录必须修改
 	db.Exec(`INSERT OR IGNORE INTO users (id, name, nickName, pwd, role, ip, createdAt) VALUES (999 , "adminPlus", "超级管理员", "admin@123456", "admin+", "127.0.0.1", ?)`, time.Now().Format("2006-01-02 15:04:05"))
 	db.Exec(`INSERT OR IGNORE INTO users (id, name, nickName, pwd, role, ip, createdAt) VALUES (1000 , "admin", "管理员", "admin@123", "admin", "127.0.0.1", ?)`, time.Now().Format("2006-01-02 15:04:05"))
+	if err := migratePlaintextPasswords(db); err != nil {
+		return sdb, fmt.Errorf("迁移用户密码失败: %v", err)
+	}
 	// 初始化客户端设置表结构
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS settings (
 		"sId" INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -73,6 +79,18 @@ func InitDB(dbPath string) (SqlliteDB, error) {
 	)`); err != nil {
 		return sdb, fmt.Errorf("初始化客户端设置表结构失败: %v", err)
 	}
+	if err := AddColumnIfNotExists(db, "settings", "trashRetentionDays", `INTEGER NOT NULL DEFAULT 30`); err != nil {
+		return sdb, fmt.Errorf("升级客户端设置表结构失败: %v", err)
+	}
+	if err := AddColumnIfNotExists(db, "settings", "maxFileVersions", `INTEGER NOT NULL DEFAULT 10`); err != nil {
+		return sdb, fmt.Errorf("升级客户端设置表结构失败: %v", err)
+	}
+	// 传输限速（KB/s），0表示不限速
+	for _, column := range []string{"uploadRateLimit", "downloadRateLimit", "userUploadRateLimit", "userDownloadRateLimit"} {
+		if err := AddColumnIfNotExists(db, "settings", column, `INTEGER NOT NULL DEFAULT 0`); err != nil {
+			return sdb, fmt.Errorf("升级客户端设置表结构失败: %v", err)
+		}
+	}
 	// 初始化聊天记录表结构
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS chat_records (
 		"cId" INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -88,6 +106,8 @@ func InitDB(dbPath string) (SqlliteDB, error) {
 	)`); err != nil {
 		return sdb, fmt.Errorf("初始化聊天记录表结构失败: %v", err)
 	}
+	// 聊天记录按好友分页查询
+	db.Exec(`CREATE INDEX IF NOT EXISTS idx_chat_records_pair ON chat_records(fromId, toId, time)`)
 	// 初始化好友表结构
 	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS friendships (
 		"fId" INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -102,10 +122,183 @@ func InitDB(dbPath string) (SqlliteDB, error) {
 	CREATE INDEX IF NOT EXISTS idx_friendships_status ON friendships(status);`); err != nil {
 		return sdb, fmt.Errorf("初始化好友表结构失败: %v", err)
 	}
+	// 初始化分片上传表结构（断点续传）
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS uploads (
+		"uploadId" TEXT PRIMARY KEY,
+		"userId" INTEGER NOT NULL,
+		"fileName" TEXT NOT NULL,
+		"fileSize" INTEGER NOT NULL,
+		"receivedSize" INTEGER NOT NULL DEFAULT 0,
+		"chunkSize" INTEGER NOT NULL,
+		"tempPath" TEXT NOT NULL,
+		"status" TEXT NOT NULL,
+		"createdAt" TEXT,
+		"modifiedAt" TEXT
+	);
+	CREATE INDEX IF NOT EXISTS idx_uploads_user ON uploads(userId, status);`); err != nil {
+		return sdb, fmt.Errorf("初始化分片上传表结构失败: %v", err)
+	}
+	if err := AddColumnIfNotExists(db, "uploads", "target", `TEXT NOT NULL DEFAULT 'shared'`); err != nil {
+		return sdb, fmt.Errorf("升级分片上传表结构失败: %v", err)
+	}
+	if err := AddColumnIfNotExists(db, "uploads", "expectedHash", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return sdb, fmt.Errorf("升级分片上传表结构失败: %v", err)
+	}
+	if err := AddColumnIfNotExists(db, "uploads", "conflict", `TEXT NOT NULL DEFAULT 'overwrite'`); err != nil {
+		return sdb, fmt.Errorf("升级分片上传表结构失败: %v", err)
+	}
+	// 初始化分享链接表结构
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS share_links (
+		"linkId" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"code" TEXT NOT NULL,
+		"fileCode" TEXT NOT NULL,
+		"userId" INTEGER NOT NULL,
+		"password" TEXT NOT NULL DEFAULT '',
+		"expiresAt" TEXT NOT NULL DEFAULT '',
+		"maxDownloads" INTEGER NOT NULL DEFAULT 0,
+		"downloadCount" INTEGER NOT NULL DEFAULT 0,
+		"revokedAt" TEXT NOT NULL DEFAULT '',
+		"createdAt" TEXT,
+		CONSTRAINT "code unique" UNIQUE ("code")
+	);
+	CREATE INDEX IF NOT EXISTS idx_share_links_user ON share_links(userId);
+	CREATE TABLE IF NOT EXISTS share_downloads (
+		"dId" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"linkId" INTEGER NOT NULL,
+		"ip" TEXT,
+		"userAgent" TEXT,
+		"time" TEXT,
+		CONSTRAINT "linkId" FOREIGN KEY ("linkId") REFERENCES "share_links" ("linkId") ON DELETE NO ACTION ON UPDATE NO ACTION
+	);
+	CREATE INDEX IF NOT EXISTS idx_share_downloads_link ON share_downloads(linkId);`); err != nil {
+		return sdb, fmt.Errorf("初始化分享链接表结构失败: %v", err)
+	}
+	// 初始化回收站表结构
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS trash (
+		"trashId" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"fileId" INTEGER NOT NULL,
+		"fileCode" TEXT NOT NULL,
+		"fileName" TEXT NOT NULL,
+		"relPath" TEXT NOT NULL,
+		"isDir" INTEGER NOT NULL,
+		"fileSize" INTEGER NOT NULL,
+		"trashPath" TEXT NOT NULL,
+		"deletedBy" INTEGER NOT NULL,
+		"deletedByName" TEXT,
+		"deletedAt" TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_trash_deletedAt ON trash(deletedAt);`); err != nil {
+		return sdb, fmt.Errorf("初始化回收站表结构失败: %v", err)
+	}
+	// 回收站条目与files表中的墓碑记录通过trashKey（回收站中的随机目录名）关联，旧版本的条目从trashPath补全
+	if err := AddColumnIfNotExists(db, "trash", "trashKey", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return sdb, fmt.Errorf("升级回收站表结构失败: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE trash SET trashKey = substr(trashPath, 1, instr(trashPath, '/') - 1) WHERE trashKey = ''`); err != nil {
+		return sdb, fmt.Errorf("升级回收站表结构失败: %v", err)
+	}
+	// 初始化上传策略表和用户存储用量表
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS upload_policies (
+		"policyId" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"scope" TEXT NOT NULL,
+		"subject" TEXT NOT NULL DEFAULT '',
+		"quotaBytes" INTEGER,
+		"maxFileSize" INTEGER,
+		"allowedExts" TEXT NOT NULL DEFAULT '',
+		"blockedExts" TEXT NOT NULL DEFAULT '',
+		"allowedMimes" TEXT NOT NULL DEFAULT '',
+		"blockedMimes" TEXT NOT NULL DEFAULT '',
+		"updatedAt" TEXT,
+		CONSTRAINT "scope subject unique" UNIQUE ("scope", "subject")
+	);
+	CREATE TABLE IF NOT EXISTS user_files (
+		"ufId" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"userId" INTEGER NOT NULL,
+		"target" TEXT NOT NULL,
+		"fileKey" TEXT NOT NULL,
+		"fileName" TEXT NOT NULL,
+		"fileSize" INTEGER NOT NULL,
+		"createdAt" TEXT,
+		CONSTRAINT "target fileKey unique" UNIQUE ("target", "fileKey")
+	);
+	CREATE INDEX IF NOT EXISTS idx_user_files_user ON user_files(userId);`); err != nil {
+		return sdb, fmt.Errorf("初始化上传策略表结构失败: %v", err)
+	}
+	// 初始化共享文件历史版本表结构
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS file_versions (
+		"versionId" INTEGER PRIMARY KEY AUTOINCREMENT,
+		"fileCode" TEXT NOT NULL,
+		"fileName" TEXT NOT NULL,
+		"fileSize" INTEGER NOT NULL,
+		"fileHash" TEXT NOT NULL DEFAULT '',
+		"fileModTime" TEXT NOT NULL,
+		"storePath" TEXT NOT NULL,
+		"createdBy" INTEGER NOT NULL,
+		"createdByName" TEXT,
+		"createdAt" TEXT NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_file_versions_fileCode ON file_versions(fileCode);`); err != nil {
+		return sdb, fmt.Errorf("初始化历史版本表结构失败: %v", err)
+	}
+	// 初始化登录会话表结构（刷新token只保存哈希）
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		"sessionId" TEXT PRIMARY KEY,
+		"userId" INTEGER NOT NULL,
+		"refreshHash" TEXT NOT NULL,
+		"prevRefreshHash" TEXT NOT NULL DEFAULT '',
+		"refreshTTL" INTEGER NOT NULL,
+		"createdAt" TEXT NOT NULL,
+		"refreshedAt" TEXT NOT NULL,
+		"expiresAt" TEXT NOT NULL,
+		"revokedAt" TEXT NOT NULL DEFAULT '',
+		"revokeReason" TEXT NOT NULL DEFAULT ''
+	);
+	CREATE INDEX IF NOT EXISTS idx_sessions_user ON sessions(userId, revokedAt);`); err != nil {
+		return sdb, fmt.Errorf("初始化登录会话表结构失败: %v", err)
+	}
+	// 登录会话的设备信息：设备名称、IP、UA、最后活跃时间、当前访问token的ID，以及签发时的角色
+	for _, column := range []string{"deviceName", "ip", "userAgent", "lastSeenAt", "tokenId", "role"} {
+		if err := AddColumnIfNotExists(db, "sessions", column, `TEXT NOT NULL DEFAULT ''`); err != nil {
+			return sdb, fmt.Errorf("升级登录会话表结构失败: %v", err)
+		}
+	}
+	// 初始化角色表结构（permissions为逗号分隔的权限名，内置角色不能删除）
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS roles (
+		"name" TEXT PRIMARY KEY,
+		"label" TEXT NOT NULL,
+		"permissions" TEXT NOT NULL DEFAULT '',
+		"builtin" INTEGER NOT NULL DEFAULT 0,
+		"updatedAt" TEXT NOT NULL
+	)`); err != nil {
+		return sdb, fmt.Errorf("初始化角色表结构失败: %v", err)
+	}
 	sdb.DB = db
 	return sdb, nil
 }
 
+// 表中不存在该字段时新增字段，用于旧版本数据库结构升级
+func AddColumnIfNotExists(db *sql.DB, table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info("%s")`, table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf(`ALTER TABLE "%s" ADD COLUMN "%s" %s`, table, column, definition))
+	return err
+}
+
 // 检测是否为json字符串格式
 func isJSON(s string) bool {
 	s = strings.TrimSpace(s)
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/*
+用户密码使用bcrypt加盐哈希保存，users.pwd 只存哈希值
+  - 旧版本数据库中的明文密码在启动时一次性迁移为哈希
+  - 管理员账号的明文密码（默认密码）迁移后标记 mustChangePwd，首次登录必须修改密码
+*/
+
+const (
+	PasswordMinLength = 8
+	PasswordMaxLength = 72 // bcrypt只使用前72字节
+)
+
+// 账号不存在时用于比对的哈希，使登录耗时与账号是否存在无关
+const dummyPasswordHash = "$2a$10$sotzlH2yZPhgyX/JMgAyG.Z6eSM6fKlUSUex2ZYAENEMFCH25twSC"
+
+// 生成密码哈希
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// 校验密码，hash为空时与占位哈希比对并返回false
+func CheckPassword(hash string, password string) bool {
+	if hash == "" {
+		bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// 是否为bcrypt哈希
+func IsPasswordHash(pwd string) bool {
+	return strings.HasPrefix(pwd, "$2a$") || strings.HasPrefix(pwd, "$2b$") || strings.HasPrefix(pwd, "$2y$")
+}
+
+// 校验新密码是否符合要求
+func ValidatePassword(password string) error {
+	if len(password) < PasswordMinLength {
+		return fmt.Errorf("密码长度不能少于%d位", PasswordMinLength)
+	}
+	if len(password) > PasswordMaxLength {
+		return fmt.Errorf("密码长度不能超过%d字节", PasswordMaxLength)
+	}
+	return nil
+}
+
+// 将明文密码迁移为哈希，管理员账号标记为需要修改密码
+func migratePlaintextPasswords(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, pwd, role FROM users`)
+	if err != nil {
+		return err
+	}
+	type plainUser struct {
+		id   int64
+		pwd  string
+		role string
+	}
+	var plainUsers []plainUser
+	for rows.Next() {
+		var u plainUser
+		if err := rows.Scan(&u.id, &u.pwd, &u.role); err != nil {
+			rows.Close()
+			return err
+		}
+		if !IsPasswordHash(u.pwd) {
+			plainUsers = append(plainUsers, u)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, u := range plainUsers {
+		hash, err := HashPassword(u.pwd)
+		if err != nil {
+			return fmt.Errorf("用户%d密码哈希失败: %v", u.id, err)
+		}
+		mustChange := 0
+		if u.role == "admin+" || u.role == "admin" {
+			mustChange = 1
+		}
+		if _, err := db.Exec(`UPDATE users SET pwd = ?, mustChangePwd = MAX(mustChangePwd, ?) WHERE id = ? AND pwd = ?`, hash, mustChange, u.id, u.pwd); err != nil {
+			return err
+		}
+	}
+	if len(plainUsers) > 0 {
+		log.Printf("已将%d个用户的明文密码迁移为哈希", len(plainUsers))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package fsListen
+
+import (
+	"database/sql"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 上传等接口先写入同目录下的临时文件，完成后rename为正式文件名，读取方不会看到写了一半的文件；
+// 监听和索引均忽略临时文件，异常退出残留的临时文件在启动同步时清理
+const (
+	TempFileSuffix    = ".ldtmp"
+	staleTempFileTime = time.Hour
+)
+
+// 是否为写入中的临时文件
+func IsTempFile(name string) bool {
+	return strings.HasSuffix(filepath.Base(name), TempFileSuffix)
+}
+
+// 以下函数供文件管理接口在修改磁盘后立即同步索引，接口返回时即可查询到最新结果；
+// 与监听协程共用索引写入锁，随后到达的监听事件会发现索引已是最新状态，不会重复插入记录。
+
+// 索引新增的文件或目录（目录递归索引）
+func IndexPath(db *sql.DB, watchDir string, absPath string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	return indexPath(db, watchDir, absPath)
+}
+
+func indexPath(db *sql.DB, watchDir string, absPath string) error {
+	if IsTempFile(absPath) {
+		return nil
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return indexTree(db, nil, watchDir, absPath)
+	}
+	return upsertEntry(db, watchDir, absPath, info)
+}
+
+// 文件或目录被重命名/移动后更新索引，保留fileCode
+func MoveIndexedPath(db *sql.DB, watchDir string, oldAbsPath string, newAbsPath string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	oldRelPath, err := relPathOf(watchDir, oldAbsPath)
+	if err != nil {
+		return err
+	}
+	newRelPath, err := relPathOf(watchDir, newAbsPath)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(newAbsPath)
+	if err != nil {
+		return err
+	}
+	fileId := lookupFileId(db, oldRelPath)
+	if fileId <= 0 { // 旧路径未被索引，按新文件处理
+		return indexPath(db, watchDir, newAbsPath)
+	}
+	return moveEntry(db, watchDir, fileId, oldRelPath, newRelPath, info)
+}
+
+// 文件或目录移入回收站后标记索引记录，trashKey为回收站条目的标识（还原时用于找回原记录）
+func RemoveIndexedPath(db *sql.DB, watchDir string, absPath string, trashKey string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	relPath, err := relPathOf(watchDir, absPath)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE files SET deletedAt = ?, trashKey = ? WHERE deletedAt IS NULL AND (relPath = ? OR relPath LIKE ? ESCAPE '\')`,
+		time.Now().Format("2006-01-02 15:04:05"), trashKey, relPath, likePrefix(relPath))
+	return err
+}
+
+// 从回收站还原后恢复同一回收站条目的索引记录（保留原fileCode），再同步磁盘上的实际状态
+func RestoreIndexedPath(db *sql.DB, watchDir string, absPath string, trashKey string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	relPath, err := relPathOf(watchDir, absPath)
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE files SET deletedAt = NULL, trashKey = NULL WHERE trashKey = ? AND (relPath = ? OR relPath LIKE ? ESCAPE '\')
+		AND NOT EXISTS (SELECT 1 FROM files live WHERE live.relPath = files.relPath AND live.deletedAt IS NULL)`,
+		trashKey, relPath, likePrefix(relPath)); err != nil {
+		return err
+	}
+	if fileId := lookupFileId(db, relPath); fileId > 0 { // 上级目录可能已变化
+		if _, err := db.Exec(`UPDATE files SET parentId = ? WHERE fileId = ?`, max(lookupFileId(db, path.Dir(relPath)), 0), fileId); err != nil {
+			return err
+		}
+	}
+	return indexPath(db, watchDir, absPath)
+}
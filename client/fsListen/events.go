@@ -0,0 +1,177 @@
+package fsListen
+
+import (
+	"database/sql"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	renamePairWindow  = time.Second            // Rename后在该时间内出现的Create视为同一文件的移动
+	writeDebounceTime = 500 * time.Millisecond // 连续写入在静默该时间后才更新索引
+	eventTickInterval = 200 * time.Millisecond // 延迟事件的检查周期
+)
+
+// 等待与Create配对的Rename事件（旧路径）
+type pendingRename struct {
+	fileId  int64
+	relPath string
+	isDir   bool
+	size    int64
+	modTime string
+	at      time.Time
+}
+
+/*
+eventBatcher 合并监听事件，只在监听循环所在的goroutine中使用：
+  - Rename 先记录旧路径，与随后的Create配对后原地更新记录（保留fileId、fileCode），超时未配对视为移出共享目录
+  - Write  按路径合并，静默 writeDebounceTime 后才写入一次索引，避免大文件复制时频繁更新
+*/
+type eventBatcher struct {
+	db       *sql.DB
+	watcher  *fsnotify.Watcher
+	watchDir string
+	renames  []pendingRename
+	writes   map[string]time.Time // 绝对路径 => 最近一次写入时间
+}
+
+func newEventBatcher(db *sql.DB, watcher *fsnotify.Watcher, watchDir string) *eventBatcher {
+	return &eventBatcher{
+		db:       db,
+		watcher:  watcher,
+		watchDir: watchDir,
+		writes:   map[string]time.Time{},
+	}
+}
+
+// 记录被重命名（移走）的旧路径
+func (b *eventBatcher) onRename(absPath string, relPath string) {
+	unwatchTree(b.watcher, absPath)
+	delete(b.writes, absPath)
+	var p pendingRename
+	err := b.db.QueryRow(`SELECT fileId, isDir, fileSize, fileModTime FROM files WHERE relPath = ? AND deletedAt IS NULL`, relPath).
+		Scan(&p.fileId, &p.isDir, &p.size, &p.modTime)
+	if err != nil {
+		return // 未索引的文件无需跟踪
+	}
+	p.relPath = relPath
+	p.at = time.Now()
+	b.renames = append(b.renames, p)
+}
+
+// 处理新建事件，能与Rename配对时更新原记录，否则作为新文件索引
+func (b *eventBatcher) onCreate(absPath string, relPath string) error {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return err
+	}
+	if p, ok := b.takeRename(relPath, info); ok {
+		log.Printf("移动文件: %s => %s", p.relPath, relPath)
+		if !info.IsDir() && lookupFileId(b.db, relPath) > 0 {
+			// 覆盖已有文件（如编辑器先写临时文件再改名保存），保留目标文件的记录
+			if err := removeEntry(b.db, p.relPath); err != nil {
+				return err
+			}
+			return upsertEntry(b.db, b.watchDir, absPath, info)
+		}
+		if err := moveEntry(b.db, b.watchDir, p.fileId, p.relPath, relPath, info); err != nil {
+			return err
+		}
+	}
+	if info.IsDir() { // 目录：为目录及子目录添加监听，并补充索引目录中的内容
+		return indexTree(b.db, b.watcher, b.watchDir, absPath)
+	}
+	return upsertEntry(b.db, b.watchDir, absPath, info)
+}
+
+// 查找与新路径匹配的Rename事件：类型一致，文件还需大小、修改时间一致（重命名不会改变这两项），优先同名（移动）
+func (b *eventBatcher) takeRename(relPath string, info fs.FileInfo) (pendingRename, bool) {
+	modTime := info.ModTime().Format("2006-01-02 15:04:05")
+	match := -1
+	for i := len(b.renames) - 1; i >= 0; i-- {
+		p := b.renames[i]
+		if p.isDir != info.IsDir() || (!p.isDir && (p.size != info.Size() || p.modTime != modTime)) {
+			continue
+		}
+		if path.Base(p.relPath) == path.Base(relPath) {
+			match = i
+			break
+		}
+		if match < 0 {
+			match = i
+		}
+	}
+	if match < 0 {
+		return pendingRename{}, false
+	}
+	p := b.renames[match]
+	b.renames = append(b.renames[:match], b.renames[match+1:]...)
+	return p, true
+}
+
+// 记录写入事件，等待合并
+func (b *eventBatcher) onWrite(absPath string) {
+	b.writes[absPath] = time.Now()
+}
+
+// 删除事件：丢弃尚未处理的写入
+func (b *eventBatcher) onRemove(absPath string, relPath string) error {
+	delete(b.writes, absPath)
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	return removeEntry(b.db, relPath)
+}
+
+// 处理到期的延迟事件，返回是否有文件内容发生变化
+func (b *eventBatcher) flush(now time.Time) bool {
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	changed := false
+	for absPath, at := range b.writes {
+		if now.Sub(at) < writeDebounceTime {
+			continue
+		}
+		delete(b.writes, absPath)
+		info, err := os.Stat(absPath)
+		if err != nil {
+			continue
+		}
+		if err := upsertEntry(b.db, b.watchDir, absPath, info); err != nil {
+			log.Println("[x]更新数据库失败:", err)
+			continue
+		}
+		changed = true
+	}
+	kept := b.renames[:0]
+	for _, p := range b.renames {
+		if now.Sub(p.at) < renamePairWindow {
+			kept = append(kept, p)
+			continue
+		}
+		// 超时未配对：文件被移出共享目录
+		if err := removeMovedEntry(b.db, p); err != nil {
+			log.Println("[x]删除旧文件名数据库记录失败:", err)
+		}
+	}
+	b.renames = kept
+	return changed
+}
+
+// 按fileId标记已移走的记录（旧路径可能已被新文件占用）
+func removeMovedEntry(db *sql.DB, p pendingRename) error {
+	if _, err := db.Exec(`UPDATE files SET deletedAt = ? WHERE fileId = ? AND deletedAt IS NULL`, time.Now().Format("2006-01-02 15:04:05"), p.fileId); err != nil {
+		return err
+	}
+	if !p.isDir {
+		return nil
+	}
+	_, err := db.Exec(`UPDATE files SET deletedAt = ? WHERE deletedAt IS NULL AND relPath LIKE ? ESCAPE '\'`, time.Now().Format("2006-01-02 15:04:05"), likePrefix(p.relPath))
+	return err
+}
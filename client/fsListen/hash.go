@@ -0,0 +1,134 @@
+package fsListen
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	hashSweepInterval = 30 * time.Second // 定时补扫未计算哈希的文件
+	hashSettleTime    = 2 * time.Second  // 文件最近修改时间小于该值视为仍在写入，稍后再计算
+)
+
+var onFileHashed atomic.Pointer[func(absPath string, hash string)]
+
+// 注册文件哈希计算完成后的回调（例如生成缩略图），回调在哈希计算协程中执行，不应阻塞
+func SetHashHook(fn func(absPath string, hash string)) {
+	onFileHashed.Store(&fn)
+}
+
+// 计算文件SHA-256（流式读取，不会整体读入内存）
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// 后台哈希计算器：只处理fileHash为空的文件，监听循环只负责通知，不会被阻塞
+type fileHasher struct {
+	db       *sql.DB
+	watchDir string
+	notify   chan struct{}
+	done     chan struct{}
+}
+
+func newFileHasher(db *sql.DB, watchDir string) *fileHasher {
+	return &fileHasher{
+		db:       db,
+		watchDir: watchDir,
+		notify:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// 通知有新的文件需要计算哈希（非阻塞）
+func (h *fileHasher) Notify() {
+	select {
+	case h.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (h *fileHasher) Stop() {
+	close(h.done)
+}
+
+func (h *fileHasher) Run() {
+	ticker := time.NewTicker(hashSweepInterval)
+	defer ticker.Stop()
+	for {
+		h.hashPending()
+		select {
+		case <-h.done:
+			return
+		case <-h.notify:
+		case <-ticker.C:
+		}
+	}
+}
+
+// 逐个计算待处理文件的哈希
+func (h *fileHasher) hashPending() {
+	rows, err := h.db.Query(`SELECT relPath FROM files WHERE isDir = 0 AND fileHash = '' AND deletedAt IS NULL`)
+	if err != nil {
+		log.Println("[x]查询待计算哈希文件失败:", err)
+		return
+	}
+	var relPaths []string
+	for rows.Next() {
+		var relPath string
+		if rows.Scan(&relPath) == nil {
+			relPaths = append(relPaths, relPath)
+		}
+	}
+	rows.Close()
+	for _, relPath := range relPaths {
+		select {
+		case <-h.done:
+			return
+		default:
+		}
+		h.hashOne(relPath)
+	}
+}
+
+func (h *fileHasher) hashOne(relPath string) {
+	fullPath := filepath.Join(h.watchDir, filepath.FromSlash(relPath))
+	before, err := os.Stat(fullPath)
+	if err != nil || before.IsDir() {
+		return
+	}
+	if time.Since(before.ModTime()) < hashSettleTime { // 仍在写入，等待下次补扫
+		return
+	}
+	sum, err := HashFile(fullPath)
+	if err != nil {
+		log.Println("[x]计算文件哈希失败:", err)
+		return
+	}
+	after, err := os.Stat(fullPath)
+	if err != nil || after.Size() != before.Size() || !after.ModTime().Equal(before.ModTime()) { // 计算期间文件被修改
+		return
+	}
+	if _, err := h.db.Exec(`UPDATE files SET fileHash = ? WHERE relPath = ? AND fileSize = ? AND deletedAt IS NULL`, sum, relPath, after.Size()); err != nil {
+		log.Println("[x]更新文件哈希失败:", err)
+		return
+	}
+	if hook := onFileHashed.Load(); hook != nil {
+		(*hook)(fullPath, sum)
+	}
+}
@@ -0,0 +1,349 @@
+package fsListen
+
+import (
+	dbutil "LanDrop/client/db"
+	"database/sql"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const tombstoneRetention = 30 * 24 * time.Hour // 墓碑记录保留30天
+
+// 索引写入锁：文件管理接口和监听协程都会修改files表，upsertEntry先查询再插入，
+// 不加锁时同一路径可能被两边同时插入而产生两条有效记录
+var indexMutex sync.Mutex
+
+// 文件相对共享目录的路径，统一使用 / 分隔
+func relPathOf(watchDir string, absPath string) (string, error) {
+	rel, err := filepath.Rel(watchDir, absPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// 相对路径对应的静态访问地址，每一级目录分别转义
+func sharedURL(relPath string) string {
+	parts := strings.Split(relPath, "/")
+	for i, p := range parts {
+		parts[i] = url.PathEscape(p)
+	}
+	return "/shared/" + strings.Join(parts, "/")
+}
+
+// 查询相对路径对应的fileId，根目录为0
+func lookupFileId(db *sql.DB, relPath string) int64 {
+	if relPath == "." || relPath == "" {
+		return 0
+	}
+	var id int64
+	if err := db.QueryRow(`SELECT fileId FROM files WHERE relPath = ? AND deletedAt IS NULL`, relPath).Scan(&id); err != nil {
+		return -1
+	}
+	return id
+}
+
+// 新增或更新一条文件记录，已存在时只更新大小和修改时间（变化时清空哈希等待重新计算）
+func upsertEntry(db *sql.DB, watchDir string, absPath string, info fs.FileInfo) error {
+	relPath, err := relPathOf(watchDir, absPath)
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime().Format("2006-01-02 15:04:05")
+	if id := lookupFileId(db, relPath); id > 0 {
+		_, err := db.Exec(`UPDATE files SET fileSize = ?, fileMode = ?, fileModTime = ?, isDir = ?, fileHash = CASE WHEN fileSize = ? AND fileModTime = ? THEN fileHash ELSE '' END WHERE fileId = ?`,
+			info.Size(), info.Mode().String(), modTime, boolToInt(info.IsDir()), info.Size(), modTime, id)
+		if err == nil {
+			refreshSearchEntry(db, id, absPath, info)
+		}
+		return err
+	}
+	parentId := lookupFileId(db, path.Dir(relPath))
+	if parentId < 0 {
+		log.Printf("[x]未找到上级目录记录: %s", relPath)
+		parentId = 0
+	}
+	// 同路径下大小、修改时间都未变化的墓碑记录直接恢复（例如共享目录所在磁盘临时断开），保留原fileCode
+	var tombId int64
+	err = db.QueryRow(`SELECT fileId FROM files WHERE relPath = ? AND deletedAt IS NOT NULL AND fileSize = ? AND fileModTime = ? AND isDir = ? ORDER BY deletedAt DESC LIMIT 1`,
+		relPath, info.Size(), modTime, boolToInt(info.IsDir())).Scan(&tombId)
+	if err == nil {
+		_, err = db.Exec(`UPDATE files SET deletedAt = NULL, parentId = ?, fileMode = ? WHERE fileId = ?`, parentId, info.Mode().String(), tombId)
+		if err == nil {
+			refreshSearchEntry(db, tombId, absPath, info)
+		}
+		return err
+	}
+	fileCode, err := generateRandomCode(6, db)
+	if err != nil {
+		return err
+	}
+	res, err := db.Exec(`INSERT INTO files (fileName, fileSize, fileMode, fileModTime, isDir, uriName, path, fileCode, relPath, parentId) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		info.Name(), info.Size(), info.Mode().String(), modTime, boolToInt(info.IsDir()), url.PathEscape(info.Name()), sharedURL(relPath), fileCode, relPath, parentId)
+	if err != nil {
+		return err
+	}
+	if id, err := res.LastInsertId(); err == nil {
+		refreshSearchEntry(db, id, absPath, info)
+	}
+	return nil
+}
+
+// 标记记录为已删除（墓碑），目录同时标记其下所有子孙记录；保留记录可避免fileCode被复用
+func removeEntry(db *sql.DB, relPath string) error {
+	_, err := db.Exec(`UPDATE files SET deletedAt = ? WHERE deletedAt IS NULL AND (relPath = ? OR relPath LIKE ? ESCAPE '\')`,
+		time.Now().Format("2006-01-02 15:04:05"), relPath, likePrefix(relPath))
+	return err
+}
+
+// 移动记录到新路径，保留fileId和fileCode；目录同时更新其下所有子孙记录的路径
+func moveEntry(db *sql.DB, watchDir string, fileId int64, oldRelPath string, newRelPath string, info fs.FileInfo) error {
+	if err := moveEntryTx(db, fileId, oldRelPath, newRelPath, info); err != nil {
+		return err
+	}
+	refreshSearchEntry(db, fileId, filepath.Join(watchDir, filepath.FromSlash(newRelPath)), info)
+	return nil
+}
+
+func moveEntryTx(db *sql.DB, fileId int64, oldRelPath string, newRelPath string, info fs.FileInfo) error {
+	parentId := lookupFileId(db, path.Dir(newRelPath))
+	if parentId < 0 {
+		parentId = 0
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// 目标路径上残留的记录标记为墓碑
+	deletedAt := time.Now().Format("2006-01-02 15:04:05")
+	if _, err := tx.Exec(`UPDATE files SET deletedAt = ? WHERE deletedAt IS NULL AND fileId != ? AND (relPath = ? OR relPath LIKE ? ESCAPE '\')`,
+		deletedAt, fileId, newRelPath, likePrefix(newRelPath)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE files SET fileName = ?, uriName = ?, path = ?, relPath = ?, parentId = ?, fileModTime = ? WHERE fileId = ?`,
+		info.Name(), url.PathEscape(info.Name()), sharedURL(newRelPath), newRelPath, parentId, info.ModTime().Format("2006-01-02 15:04:05"), fileId); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		rows, err := tx.Query(`SELECT fileId, relPath FROM files WHERE deletedAt IS NULL AND relPath LIKE ? ESCAPE '\'`, likePrefix(oldRelPath))
+		if err != nil {
+			return err
+		}
+		moved := map[int64]string{}
+		for rows.Next() {
+			var id int64
+			var relPath string
+			if rows.Scan(&id, &relPath) == nil {
+				moved[id] = newRelPath + strings.TrimPrefix(relPath, oldRelPath)
+			}
+		}
+		rows.Close()
+		for id, relPath := range moved {
+			if _, err := tx.Exec(`UPDATE files SET relPath = ?, path = ? WHERE fileId = ?`, relPath, sharedURL(relPath), id); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// 生成匹配子孙路径的LIKE表达式
+func likePrefix(relPath string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(relPath) + "/%"
+}
+
+// 递归索引目录并为所有子目录添加监听（watcher为nil时只索引），root为共享目录本身时不写入根记录
+func indexTree(db *sql.DB, watcher *fsnotify.Watcher, watchDir string, root string) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Println("[x]读取目录失败:", err)
+			if d != nil && d.IsDir() && p != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() && watcher != nil {
+			if err := watcher.Add(p); err != nil {
+				log.Println("[x]监听目录失败:", err)
+			}
+		}
+		if p == watchDir || IsTempFile(p) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if err := upsertEntry(db, watchDir, p, info); err != nil {
+			log.Println("[x]注入文件信息失败:", err)
+		}
+		return nil
+	})
+}
+
+// 取消目录及其子目录的监听（目录被移走或重命名时）
+func unwatchTree(watcher *fsnotify.Watcher, absPath string) {
+	prefix := absPath + string(os.PathSeparator)
+	for _, w := range watcher.WatchList() {
+		if w == absPath || strings.HasPrefix(w, prefix) {
+			watcher.Remove(w)
+		}
+	}
+}
+
+// 创建files表；旧版本（启动时重建、无relPath字段）的表结构直接重建
+func initFilesTable(db *sql.DB) error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('files') WHERE name = 'relPath'`).Scan(&count); err == nil && count == 0 {
+		if _, err := db.Exec(`DROP TABLE IF EXISTS files;`); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS files (
+			fileId INTEGER PRIMARY KEY AUTOINCREMENT,
+			fileName TEXT NOT NULL,
+			fileSize INTEGER NOT NULL,
+			fileMode  TEXT NOT NULL,
+			fileModTime TEXT NOT NULL,
+			isDir   INTEGER NOT NULL,
+			uriName TEXT NOT NULL,
+			path  TEXT NOT NULL,
+			fileCode TEXT NOT NULL,
+			fileHash TEXT NOT NULL DEFAULT '',
+			relPath TEXT NOT NULL,
+			parentId INTEGER NOT NULL DEFAULT 0,
+			deletedAt TEXT,
+			trashKey TEXT
+		);
+		CREATE INDEX IF NOT EXISTS idx_files_relPath ON files(relPath);
+		CREATE INDEX IF NOT EXISTS idx_files_parentId ON files(parentId);
+		CREATE INDEX IF NOT EXISTS idx_files_fileCode ON files(fileCode);
+	`); err != nil {
+		return err
+	}
+	if err := dbutil.AddColumnIfNotExists(db, "files", "deletedAt", "TEXT"); err != nil {
+		return err
+	}
+	// 墓碑记录通过trashKey关联回收站条目；旧版本按删除时间关联，升级时按删除时间和路径补全一次
+	var hasTrashKey int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('files') WHERE name = 'trashKey'`).Scan(&hasTrashKey); err != nil || hasTrashKey > 0 {
+		return err
+	}
+	if err := dbutil.AddColumnIfNotExists(db, "files", "trashKey", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := db.Exec(`UPDATE files SET trashKey = (SELECT t.trashKey FROM trash t WHERE t.deletedAt = files.deletedAt
+		AND (files.relPath = t.relPath OR substr(files.relPath, 1, length(t.relPath) + 1) = t.relPath || '/') ORDER BY t.trashId DESC LIMIT 1)
+		WHERE deletedAt IS NOT NULL`); err != nil {
+		log.Println("[x]关联回收站墓碑记录失败:", err)
+	}
+	return nil
+}
+
+type indexedEntry struct {
+	id      int64
+	size    int64
+	modTime string
+	isDir   bool
+}
+
+/*
+reconcileTree 对比磁盘与files表，增量同步索引：
+  - 路径、大小、修改时间均未变化的记录保持不动（fileCode不变，分享链接在重启后依然有效）
+  - 新文件插入，变化的文件更新并重新计算哈希
+  - 磁盘上已不存在的记录标记为墓碑
+*/
+func reconcileTree(db *sql.DB, watcher *fsnotify.Watcher, watchDir string) error {
+	// 旧版本并发写入可能留下同一路径的多条有效记录，只保留最早的一条
+	if _, err := db.Exec(`UPDATE files SET deletedAt = ? WHERE deletedAt IS NULL AND fileId NOT IN (SELECT MIN(fileId) FROM files WHERE deletedAt IS NULL GROUP BY relPath)`,
+		time.Now().Format("2006-01-02 15:04:05")); err != nil {
+		log.Println("[x]清理重复记录失败:", err)
+	}
+	indexed := map[string]indexedEntry{}
+	rows, err := db.Query(`SELECT fileId, relPath, fileSize, fileModTime, isDir FROM files WHERE deletedAt IS NULL`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var e indexedEntry
+		var relPath string
+		if err := rows.Scan(&e.id, &relPath, &e.size, &e.modTime, &e.isDir); err == nil {
+			indexed[relPath] = e
+		}
+	}
+	rows.Close()
+	var inserted, updated, removed int
+	err = filepath.WalkDir(watchDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Println("[x]读取目录失败:", err)
+			if d != nil && d.IsDir() && p != watchDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := watcher.Add(p); err != nil {
+				log.Println("[x]监听目录失败:", err)
+			}
+		}
+		if p == watchDir {
+			return nil
+		}
+		relPath, err := relPathOf(watchDir, p)
+		if err != nil {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if IsTempFile(p) {
+			if !d.IsDir() && time.Since(info.ModTime()) > staleTempFileTime {
+				log.Printf("清理残留的临时文件: %s", p)
+				os.Remove(p)
+			}
+			return nil
+		}
+		e, ok := indexed[relPath]
+		delete(indexed, relPath)
+		if ok && e.size == info.Size() && e.modTime == info.ModTime().Format("2006-01-02 15:04:05") && e.isDir == info.IsDir() {
+			return nil
+		}
+		if err := upsertEntry(db, watchDir, p, info); err != nil {
+			log.Println("[x]注入文件信息失败:", err)
+		} else if ok {
+			updated++
+		} else {
+			inserted++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for relPath := range indexed { // 剩余的记录在磁盘上已不存在
+		if err := removeEntry(db, relPath); err != nil {
+			log.Println("[x]标记删除记录失败:", err)
+		}
+		removed++
+	}
+	// 清理过期墓碑，回收站中的文件保留记录以便还原后沿用原fileCode
+	expiredDate := time.Now().Add(-tombstoneRetention).Format("2006-01-02 15:04:05")
+	if _, err := db.Exec(`DELETE FROM files WHERE deletedAt IS NOT NULL AND deletedAt < ? AND (trashKey IS NULL OR trashKey NOT IN (SELECT trashKey FROM trash))`, expiredDate); err != nil {
+		log.Println("[x]清理过期墓碑失败:", err)
+	}
+	log.Printf("共享目录索引同步完成: 新增 %d，更新 %d，删除 %d", inserted, updated, removed)
+	return nil
+}
@@ -0,0 +1,141 @@
+package fsListen
+
+import (
+	"database/sql"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+/*
+files_fts 全文检索索引（SQLite FTS5，trigram分词，支持中文等任意子串匹配）：
+  - rowid 与 files.fileId 一致，索引文件名、扩展名以及小体积文本文件的内容
+  - 新增、修改、移动由监听事件和文件管理接口经 upsertEntry/moveEntry 同步
+  - 标记删除由触发器同步，任何删除路径都不会遗漏
+  - 编译时需加 -tags sqlite_fts5，未启用FTS5时搜索退化为文件名LIKE匹配
+*/
+
+const maxContentIndexSize = 256 * 1024 // 只索引256KB以内的文本文件内容
+
+var searchEnabled atomic.Bool
+
+// 是否已启用全文检索
+func SearchEnabled() bool {
+	return searchEnabled.Load()
+}
+
+var textFileExts = map[string]bool{
+	".txt": true, ".md": true, ".markdown": true, ".log": true, ".csv": true, ".tsv": true,
+	".json": true, ".xml": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".conf": true,
+	".html": true, ".htm": true, ".css": true, ".scss": true, ".less": true, ".sql": true,
+	".go": true, ".js": true, ".jsx": true, ".ts": true, ".tsx": true, ".vue": true, ".py": true,
+	".java": true, ".kt": true, ".c": true, ".h": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".rs": true, ".php": true, ".rb": true, ".swift": true, ".lua": true, ".sh": true, ".bat": true, ".ps1": true,
+}
+
+// 小写扩展名（不含点），目录和无扩展名文件为空
+func fileExt(name string) string {
+	return strings.TrimPrefix(strings.ToLower(path.Ext(name)), ".")
+}
+
+// 创建全文检索表和同步删除的触发器，当前sqlite未编译FTS5时关闭全文检索
+func initSearchIndex(db *sql.DB) {
+	// 表已存在时CREATE不会报错，需实际查询一次确认FTS5可用
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS files_fts USING fts5(name, ext, content, tokenize = 'trigram');
+		SELECT rowid FROM files_fts LIMIT 0;`)
+	if err != nil {
+		log.Println("[x]全文检索不可用，搜索将只匹配文件名:", err)
+		searchEnabled.Store(false)
+		// 数据库由启用FTS5的版本创建过时，移除触发器，否则files表的删除和标记删除都会失败
+		if _, err := db.Exec(`DROP TRIGGER IF EXISTS files_fts_tombstone; DROP TRIGGER IF EXISTS files_fts_delete;`); err != nil {
+			log.Println("[x]移除全文检索触发器失败:", err)
+		}
+		return
+	}
+	_, err = db.Exec(`
+		CREATE TRIGGER IF NOT EXISTS files_fts_tombstone AFTER UPDATE OF deletedAt ON files WHEN NEW.deletedAt IS NOT NULL
+		BEGIN
+			DELETE FROM files_fts WHERE rowid = NEW.fileId;
+		END;
+		CREATE TRIGGER IF NOT EXISTS files_fts_delete AFTER DELETE ON files
+		BEGIN
+			DELETE FROM files_fts WHERE rowid = OLD.fileId;
+		END;
+	`)
+	if err != nil {
+		log.Println("[x]全文检索不可用，搜索将只匹配文件名:", err)
+		searchEnabled.Store(false)
+		return
+	}
+	searchEnabled.Store(true)
+}
+
+// 读取可索引的文本内容，非文本、过大或非UTF-8的文件返回空字符串
+func readIndexableContent(absPath string, info fs.FileInfo) string {
+	if info.IsDir() || info.Size() > maxContentIndexSize || !textFileExts[strings.ToLower(filepath.Ext(absPath))] {
+		return ""
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil || !utf8.Valid(data) {
+		return ""
+	}
+	return string(data)
+}
+
+// 更新一条记录的全文检索数据
+func refreshSearchEntry(db *sql.DB, fileId int64, absPath string, info fs.FileInfo) {
+	if !searchEnabled.Load() {
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM files_fts WHERE rowid = ?`, fileId); err != nil {
+		log.Println("[x]更新全文检索失败:", err)
+		return
+	}
+	if _, err := db.Exec(`INSERT INTO files_fts (rowid, name, ext, content) VALUES (?, ?, ?, ?)`,
+		fileId, info.Name(), fileExt(info.Name()), readIndexableContent(absPath, info)); err != nil {
+		log.Println("[x]更新全文检索失败:", err)
+	}
+}
+
+// 启动时校验全文检索与files表是否一致（首次启用、异常退出等情况），不一致时整体重建
+func syncSearchIndex(db *sql.DB, watchDir string) {
+	if !searchEnabled.Load() {
+		return
+	}
+	var live, indexed int
+	db.QueryRow(`SELECT COUNT(*) FROM files WHERE deletedAt IS NULL`).Scan(&live)
+	db.QueryRow(`SELECT COUNT(*) FROM files_fts`).Scan(&indexed)
+	if live == indexed {
+		return
+	}
+	if _, err := db.Exec(`DELETE FROM files_fts`); err != nil {
+		log.Println("[x]重建全文检索失败:", err)
+		return
+	}
+	rows, err := db.Query(`SELECT fileId, relPath FROM files WHERE deletedAt IS NULL`)
+	if err != nil {
+		log.Println("[x]重建全文检索失败:", err)
+		return
+	}
+	entries := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var relPath string
+		if rows.Scan(&id, &relPath) == nil {
+			entries[id] = relPath
+		}
+	}
+	rows.Close()
+	for id, relPath := range entries {
+		absPath := filepath.Join(watchDir, filepath.FromSlash(relPath))
+		if info, err := os.Stat(absPath); err == nil {
+			refreshSearchEntry(db, id, absPath, info)
+		}
+	}
+	log.Printf("全文检索索引重建完成: %d 条", len(entries))
+}
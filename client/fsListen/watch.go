@@ -1,14 +1,13 @@
 package fsListen
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"fmt"
 	"log"
 	"math/big"
-	"net/url"
-	"os"
-	"path/filepath"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -65,7 +64,9 @@ func generateRandomCode(length int, db *sql.DB) (string, error) {
 	}
 	return "", fmt.Errorf("存在code 重复，尝试五次还是失败")
 }
-func FSWatcher(watchDir string, db *sql.DB) {
+
+// 监听共享目录并维护files表，ctx取消时退出（服务停止或重启时）
+func FSWatcher(ctx context.Context, watchDir string, db *sql.DB) {
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		log.Println("[x]开启监听:", err)
@@ -73,95 +74,65 @@ func FSWatcher(watchDir string, db *sql.DB) {
 	}
 	defer watcher.Close()
 
-	// 读取目录进行映射到数据库
-	db.Exec(`DROP TABLE IF EXISTS files;`)
-	if _, err = db.Exec(`
-		CREATE TABLE IF NOT EXISTS files (
-			fileId INTEGER PRIMARY KEY AUTOINCREMENT,
-			fileName TEXT NOT NULL,
-			fileSize INTEGER NOT NULL,
-			fileMode  TEXT NOT NULL,
-			fileModTime TEXT NOT NULL,
-			isDir   INTEGER NOT NULL,
-			uriName TEXT NOT NULL,
-			path  TEXT NOT NULL,
-			fileCode TEXT NOT NULL
-		)
-	`); err != nil {
+	// 增量同步数据库索引（不再每次启动重建，已有文件的fileCode保持不变）
+	if err = initFilesTable(db); err != nil {
 		log.Println("[x]创建表结构:", err)
 		return
 	}
-	entries, _ := os.ReadDir(watchDir)
-	for _, entry := range entries {
-		info, _ := entry.Info()
-		fileId, codeErr := generateRandomCode(6, db)
-		if codeErr != nil {
-			log.Println("生成唯一fileId失败:", err)
-			return
-		}
-		if _, err = db.Exec(`INSERT INTO files (fileName, fileSize, fileMode, fileModTime, isDir,uriName, path, fileCode) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-			entry.Name(), info.Size(), info.Mode().String(), info.ModTime().Format("2006-01-02 15:04:05"), boolToInt(info.IsDir()), url.PathEscape(entry.Name()), "/shared/"+url.PathEscape(entry.Name()), fileId); err != nil {
-			log.Println("[x]注入文件信息失败:", err)
-			return
-		}
-	}
-
-	// 监听目录
-	err = watcher.Add(watchDir)
+	initSearchIndex(db)
+	indexMutex.Lock()
+	err = reconcileTree(db, watcher, watchDir)
+	indexMutex.Unlock()
 	if err != nil {
-		log.Println("[x]监听目录失败:", err)
+		log.Println("[x]同步共享目录索引失败:", err)
 		return
 	}
+	syncSearchIndex(db, watchDir)
+
+	// 后台计算文件哈希
+	hasher := newFileHasher(db, watchDir)
+	go hasher.Run()
+	defer hasher.Stop()
+
+	batcher := newEventBatcher(db, watcher, watchDir)
+	ticker := time.NewTicker(eventTickInterval)
+	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			log.Println("停止监听共享目录:", watchDir)
+			return
+		case now := <-ticker.C:
+			if batcher.flush(now) {
+				hasher.Notify()
+			}
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
+			relPath, relErr := relPathOf(watchDir, event.Name)
+			if relErr != nil || relPath == "." || IsTempFile(event.Name) {
+				break
+			}
 			// 处理事件类型
 			switch {
 			case event.Op&fsnotify.Create == fsnotify.Create:
 				log.Printf("创建文件: %s", event.Name)
-				info, osErr := os.Stat(event.Name)
-				if osErr != nil {
-					log.Println("获取文件信息失败:", osErr)
-					break
-				}
-				fileName := info.Name()
-				fileId, codeErr := generateRandomCode(6, db)
-				if codeErr != nil {
-					log.Println("生成唯一fileId失败:", codeErr)
-					break
-				}
-				if _, err = db.Exec(`INSERT INTO files (fileName, fileSize, fileMode, fileModTime, isDir, uriName, path, fileCode) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
-					fileName, info.Size(), info.Mode().String(), info.ModTime().String(), info.IsDir(), url.PathEscape(fileName), "/shared/"+url.PathEscape(fileName), fileId); err != nil {
+				if err = batcher.onCreate(event.Name, relPath); err != nil {
 					log.Println("[x]插入数据库失败:", err)
 				}
+				hasher.Notify()
 			case event.Op&fsnotify.Write == fsnotify.Write:
-				log.Printf("修改文件: %s", event.Name)
-				info, osErr := os.Stat(event.Name)
-				if osErr != nil {
-					log.Println("[x]获取文件信息失败:", osErr)
-					break
-				}
-				fileName := info.Name()
-				if _, err = db.Exec(`UPDATE files SET fileSize = ?, fileModTime = ? WHERE fileName = ?`,
-					info.Size(), info.ModTime().String(), fileName); err != nil {
-					log.Println("[x]更新数据库失败:", err)
-				}
+				batcher.onWrite(event.Name)
 			case event.Op&fsnotify.Remove == fsnotify.Remove:
 				log.Printf("删除文件: %s", event.Name)
-				fileName := filepath.Base(event.Name)
-				if _, err = db.Exec(`DELETE FROM files WHERE fileName = ?`, fileName); err != nil {
+				if err = batcher.onRemove(event.Name, relPath); err != nil {
 					log.Println("[x]删除数据库记录失败:", err)
 				}
 			case event.Op&fsnotify.Rename == fsnotify.Rename:
 				log.Printf("重命名文件: %s", event.Name)
-				oldFileName := filepath.Base(event.Name)
-				if _, err = db.Exec(`DELETE FROM files WHERE fileName = ?`, oldFileName); err != nil {
-					log.Println("[x]删除旧文件名数据库记录失败:", err)
-				}
+				batcher.onRename(event.Name, relPath)
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 文件夹/多文件打包下载：边读边压缩直接写入响应，不生成临时文件
+const (
+	archiveFormatZip      = "zip"
+	archiveFormatTarGz    = "tar.gz"
+	archiveStatusRunning  = "running"
+	archiveStatusDone     = "finished"
+	archiveStatusCanceled = "canceled"
+	archiveStatusFailed   = "failed"
+	archiveKeepTime       = time.Minute // 打包结束后进度信息保留时间，便于客户端查询最终状态
+)
+
+var errArchiveCanceled = errors.New("archive canceled")
+
+// 打包条目
+type archiveEntry struct {
+	absPath string
+	name    string // 压缩包内路径，使用 / 分隔
+	info    fs.FileInfo
+}
+
+// 打包进度
+type archiveProgress struct {
+	ArchiveID string `json:"archiveId"`
+	FileName  string `json:"fileName"`
+	Format    string `json:"format"`
+	TotalSize int64  `json:"totalSize"`
+	FileCount int    `json:"fileCount"`
+	DoneSize  int64  `json:"doneSize"`
+	DoneFiles int    `json:"doneFiles"`
+	Status    string `json:"status"`
+}
+
+// 打包任务，记录进度并支持取消
+type archiveJob struct {
+	archiveProgress
+	userId    int64
+	doneSize  atomic.Int64
+	doneFiles atomic.Int64
+	status    atomic.Value
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// 进度快照
+func (j *archiveJob) snapshot() archiveProgress {
+	p := j.archiveProgress
+	p.DoneSize = j.doneSize.Load()
+	p.DoneFiles = int(j.doneFiles.Load())
+	p.Status = j.status.Load().(string)
+	return p
+}
+
+var archiveJobs sync.Map // archiveId => *archiveJob
+
+// 统计写入字节数并检查取消
+type archiveProgressWriter struct {
+	w   io.Writer
+	job *archiveJob
+}
+
+func (p archiveProgressWriter) Write(b []byte) (int, error) {
+	if p.job.ctx.Err() != nil {
+		return 0, errArchiveCanceled
+	}
+	n, err := p.w.Write(b)
+	p.job.doneSize.Add(int64(n))
+	return n, err
+}
+
+// 收集需要打包的文件：目录递归展开，条目名称相对所选项目的上级目录
+func collectArchiveEntries(sharedDir string, selected []FileInfo) ([]archiveEntry, int64, error) {
+	var entries []archiveEntry
+	var total int64
+	seen := map[string]bool{}
+	for _, f := range selected {
+		root := filepath.Join(sharedDir, filepath.FromSlash(f.RelPath))
+		base := path.Dir(f.RelPath)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Println("[x]读取打包文件失败:", err)
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil || (!info.Mode().IsRegular() && !info.IsDir()) { // 跳过符号链接等特殊文件
+				return nil
+			}
+			rel, err := filepath.Rel(sharedDir, p)
+			if err != nil {
+				return nil
+			}
+			rel = filepath.ToSlash(rel)
+			if seen[rel] {
+				return nil
+			}
+			seen[rel] = true
+			name := rel
+			if base != "." {
+				name = strings.TrimPrefix(rel, base+"/")
+			}
+			entries = append(entries, archiveEntry{absPath: p, name: name, info: info})
+			if !info.IsDir() {
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return entries, total, nil
+}
+
+// 写入单个文件内容
+func copyArchiveFile(w io.Writer, e archiveEntry) error {
+	f, err := os.Open(e.absPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, io.LimitReader(f, e.info.Size())) // 以收集时的大小为准，避免打包过程中文件被追加写入导致头信息不一致
+	return err
+}
+
+func writeZipArchive(w io.Writer, job *archiveJob, entries []archiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		header, err := zip.FileInfoHeader(e.info)
+		if err != nil {
+			return err
+		}
+		header.Name = e.name
+		if e.info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if !e.info.IsDir() {
+			if err := copyArchiveFile(archiveProgressWriter{w: fw, job: job}, e); err != nil {
+				return err
+			}
+			job.doneFiles.Add(1)
+		}
+	}
+	return zw.Close()
+}
+
+func writeTarGzArchive(w io.Writer, job *archiveJob, entries []archiveEntry) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		header, err := tar.FileInfoHeader(e.info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = e.name
+		header.Format = tar.FormatPAX // 支持UTF-8长文件名
+		if e.info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !e.info.IsDir() {
+			if err := copyArchiveFile(archiveProgressWriter{w: tw, job: job}, e); err != nil {
+				return err
+			}
+			job.doneFiles.Add(1)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// 解析打包范围：fileCodes（逗号分隔）或path（目录相对路径）
+func (r Router) resolveArchiveSelection(c *fiber.Ctx) ([]FileInfo, error) {
+	var selected []FileInfo
+	for _, code := range strings.Split(c.Query("fileCodes"), ",") {
+		if code = strings.TrimSpace(code); code == "" {
+			continue
+		}
+		f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? AND deletedAt IS NULL", code))
+		if err != nil {
+			return nil, errors.New("文件不存在: " + code)
+		}
+		selected = append(selected, f)
+	}
+	if dirPath := strings.Trim(c.Query("path"), "/"); dirPath != "" {
+		f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE relPath = ? AND deletedAt IS NULL", dirPath))
+		if err != nil {
+			return nil, errors.New("目录不存在: " + dirPath)
+		}
+		selected = append(selected, f)
+	}
+	if len(selected) == 0 {
+		return nil, errors.New("请选择需要下载的文件或目录")
+	}
+	return selected, nil
+}
+
+// 打包下载，GET /api/v1/downloadArchive?fileCodes=A,B&path=dir&format=zip|tar.gz&archiveId=xxx
+// archiveId可由客户端指定，用于查询进度和取消
+func (r Router) downloadArchive(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	format := c.Query("format", archiveFormatZip)
+	if format != archiveFormatZip && format != archiveFormatTarGz {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "不支持的压缩格式",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	selected, err := r.resolveArchiveSelection(c)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	return r.sendArchive(c, selected, format, c.Query("archiveId"), token.UserID)
+}
+
+// 打包所选文件并以流的方式写入响应，userId为0时（匿名分享链接）不记录可查询的进度
+func (r Router) sendArchive(c *fiber.Ctx, selected []FileInfo, format string, archiveId string, userId int64) error {
+	entries, total, err := collectArchiveEntries(r.config.SharedDir, selected)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "读取文件失败",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if archiveId == "" {
+		if archiveId, err = randomHex(16); err != nil {
+			return err
+		}
+	}
+	if _, exists := archiveJobs.Load(archiveId); exists {
+		r.Reply = Reply{
+			Code: http.StatusConflict,
+			Msg:  "archiveId已存在",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	baseName := "LanDrop_" + time.Now().Format("2006-01-02_15-04-05")
+	if len(selected) == 1 {
+		baseName = strings.TrimSuffix(selected[0].Name, filepath.Ext(selected[0].Name))
+		if selected[0].IsDir {
+			baseName = selected[0].Name
+		}
+	}
+	job := &archiveJob{
+		archiveProgress: archiveProgress{
+			ArchiveID: archiveId,
+			FileName:  baseName + "." + format,
+			Format:    format,
+			TotalSize: total,
+		},
+		userId: userId,
+	}
+	for _, e := range entries {
+		if !e.info.IsDir() {
+			job.FileCount++
+		}
+	}
+	job.status.Store(archiveStatusRunning)
+	job.ctx, job.cancel = context.WithCancel(context.Background())
+	archiveJobs.Store(archiveId, job)
+
+	c.Set("X-Archive-Id", archiveId)
+	c.Set("X-Archive-Total-Size", fmt.Sprint(total))
+	c.Set(fiber.HeaderContentDisposition, contentDisposition("attachment", job.FileName))
+	if format == archiveFormatZip {
+		c.Set(fiber.HeaderContentType, "application/zip")
+	} else {
+		c.Set(fiber.HeaderContentType, "application/gzip")
+	}
+	conn := c.Context().Conn()
+	bandwidth := bandwidthKey(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer func() {
+			job.cancel()
+			time.AfterFunc(archiveKeepTime, func() { archiveJobs.Delete(archiveId) })
+		}()
+		var err error
+		out := throttledWriter{Writer: w, pool: downloadBandwidth, key: bandwidth}
+		if format == archiveFormatZip {
+			err = writeZipArchive(out, job, entries)
+		} else {
+			err = writeTarGzArchive(out, job, entries)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		switch {
+		case err == nil:
+			job.status.Store(archiveStatusDone)
+			log.Printf("打包下载完成: %s (%d个文件, %d字节)", job.FileName, job.FileCount, total)
+		case errors.Is(err, errArchiveCanceled) || job.ctx.Err() != nil:
+			job.status.Store(archiveStatusCanceled)
+			log.Printf("打包下载已取消: %s", job.FileName)
+			conn.Close() // 直接断开连接，避免客户端把不完整的压缩包当作下载成功
+		default: // 通常是客户端断开连接
+			job.status.Store(archiveStatusFailed)
+			log.Println("[x]打包下载中断:", err)
+			conn.Close()
+		}
+	})
+	return nil
+}
+
+// 查询打包进度
+func (r Router) getArchiveProgress(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	v, ok := archiveJobs.Load(c.Query("archiveId"))
+	if !ok || v.(*archiveJob).userId != token.UserID {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  "打包任务不存在",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: v.(*archiveJob).snapshot(),
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 取消打包下载
+func (r Router) cancelArchive(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		ArchiveID string `json:"archiveId"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.ArchiveID == "" {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	v, ok := archiveJobs.Load(postBody.ArchiveID)
+	if !ok || v.(*archiveJob).userId != token.UserID {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  "打包任务不存在",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	v.(*archiveJob).cancel()
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: nil,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
@@ -2,14 +2,16 @@ package server
 
 import (
 	"LanDrop/client/db"
+	"crypto/rand"
 	"embed"
-	"encoding/base64"
-	"errors"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -23,8 +25,11 @@ type Router struct { // 路由
 	assets embed.FS
 	config Config
 	Reply
-	db      db.SqlliteDB
-	userDir string
+	db          db.SqlliteDB
+	userDir     string
+	tempDir     string
+	trashDir    string
+	versionsDir string
 }
 type FileInfo struct { // 文件参数
 	ID       int    `json:"fileId"`
@@ -36,6 +41,22 @@ type FileInfo struct { // 文件参数
 	URIName  string `json:"uriName"`
 	Path     string `json:"path"`
 	FileCode string `json:"fileCode"`
+	FileHash string `json:"fileHash"` // SHA-256，后台计算完成前为空
+	RelPath  string `json:"relPath"`  // 相对共享目录的路径，使用 / 分隔
+	ParentID int64  `json:"parentId"` // 上级目录fileId，根目录下为0
+	// 缩略图地址，仅支持的图片类型返回
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
+
+// files表查询字段，与scanFileInfo的扫描顺序保持一致
+const fileInfoColumns = "fileId, fileName, fileSize, fileMode, fileModTime, isDir, uriName, path, fileCode, fileHash, relPath, parentId"
+
+// 扫描一行files数据
+func scanFileInfo(scanner interface{ Scan(dest ...any) error }) (FileInfo, error) {
+	var f FileInfo
+	err := scanner.Scan(&f.ID, &f.Name, &f.Size, &f.Mode, &f.ModTime, &f.IsDir, &f.URIName, &f.Path, &f.FileCode, &f.FileHash, &f.RelPath, &f.ParentID)
+	f.Thumbnail = sharedThumbnailURL(f)
+	return f, err
 }
 
 type Reply struct { // 接口回复数据
@@ -48,11 +69,12 @@ type UserToken struct {
 	UserID               int64  `json:"userId"`
 	Username             string `json:"userName"`
 	Role                 string `json:"role"`
+	SessionID            string `json:"sid,omitempty"`       // 登录会话ID，注销会话后token立即失效
+	PwdChange            bool   `json:"pwdChange,omitempty"` // 必须先修改密码，只能访问修改密码接口
 	jwt.RegisteredClaims        // 内嵌标准Claims（过期时间等）
 }
 
-var SecretKey = []byte("KNTWcTMPxMbGPhUZskWn")    // token 密钥
-var XORSecretKey = []byte("xmn30241yv413y5b01vy") // token XOR加密密钥
+const pwdChangeTokenExpiry = 30 * time.Minute // 修改密码临时token有效期
 
 // 辅助函数：生成安全的文件名
 func generateFilename(original string) string {
@@ -64,71 +86,108 @@ func generateFilename(original string) string {
 	return fmt.Sprintf("%v-%v_%s%s", base, micro, strings.TrimSuffix(original, ext), ext)
 }
 
-// XOR加密
-func EncryptToken(token string) string {
-	keyBytes := []byte(XORSecretKey)
-	encrypted := make([]byte, len(token))
-	for i := 0; i < len(token); i++ {
-		encrypted[i] = token[i] ^ keyBytes[i%len(keyBytes)]
+// 生成指定字节长度的随机十六进制字符串
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	// 使用URL安全的Base64编码，避免特殊字符问题
-	return base64.URLEncoding.EncodeToString(encrypted)
+	return hex.EncodeToString(b), nil
 }
 
-// DecryptToken 解密方法
-func DecryptToken(encrypted string) (string, error) {
-	keyBytes := []byte(XORSecretKey)
-	// 解码Base64
-	data, err := base64.URLEncoding.DecodeString(encrypted)
+// 移动文件，跨磁盘无法rename时退化为复制后删除
+func moveFile(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(dst)
 	if err != nil {
-		return "", errors.New("invalid encrypted token format")
+		in.Close()
+		return err
 	}
-	decrypted := make([]byte, len(data))
-	for i := 0; i < len(data); i++ {
-		decrypted[i] = data[i] ^ keyBytes[i%len(keyBytes)]
+	_, err = io.Copy(out, in)
+	in.Close()
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
 	}
-	return string(decrypted), nil
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// 生成下载用的Content-Disposition，filename为ASCII兼容名称，filename*为UTF-8原始文件名
+func contentDisposition(disposition string, fileName string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, fileName)
+	return fmt.Sprintf(`%s; filename="%s"; filename*=UTF-8''%s`, disposition, fallback, url.PathEscape(fileName))
 }
 
-// 创建XOR token
-func CreateToken(role string, userId int64, userName string, expTime int) (string, error) {
-	claims := UserToken{
-		UserID:   userId,
-		Username: userName,
-		Role:     role,
+// 创建登录会话的访问token，使用当前签名密钥签名，tokenId记录在会话中
+func CreateToken(role string, userId int64, userName string, sessionId string, tokenId string) (string, error) {
+	return signToken(UserToken{
+		UserID:    userId,
+		Username:  userName,
+		Role:      role,
+		SessionID: sessionId,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(expTime) * time.Hour)), // 过期时间
-			IssuedAt:  jwt.NewNumericDate(time.Now()),                                         // 签发时间
-			Issuer:    "landrop_client",                                                       // 签发者
+			ID:        tokenId,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenExpiry)), // 过期时间
+			IssuedAt:  jwt.NewNumericDate(time.Now()),                        // 签发时间
+			Issuer:    "landrop_client",                                      // 签发者
 		},
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(SecretKey)
+	})
+}
+
+// 创建只能用于修改密码的临时token（首次登录必须修改默认密码）
+func CreatePwdChangeToken(role string, userId int64, userName string) (string, error) {
+	return signToken(UserToken{
+		UserID:    userId,
+		Username:  userName,
+		Role:      role,
+		PwdChange: true,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(pwdChangeTokenExpiry)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "landrop_client",
+		},
+	})
+}
+
+func signToken(claims UserToken) (string, error) {
+	key, err := tokenKeys.current()
 	if err != nil {
 		return "", err
 	}
-	tokenString = EncryptToken(tokenString)
-	return tokenString, nil
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.KID
+	return token.SignedString(key.Key)
 }
 
-// 解析XOR token
+// 解析token
 func ParseToken(tokenString string) (*UserToken, error) {
-	realTokenStr, err := DecryptToken(tokenString)
-	if err != nil {
-		return nil, err
-	}
 	token, err := jwt.ParseWithClaims(
-		realTokenStr,
+		tokenString,
 		&UserToken{},
-		func(token *jwt.Token) (any, error) {
-			return SecretKey, nil
-		},
+		tokenKeyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
 	)
+	if err != nil {
+		return nil, err
+	}
 	if claims, ok := token.Claims.(*UserToken); ok && token.Valid {
 		return claims, nil
-	} else {
-		return nil, err
 	}
+	return nil, jwt.ErrTokenInvalidClaims
 }
 
 // 代理服务器 将本地80端口映射到4321端口
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"LanDrop/client/fsListen"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+上传到共享目录时的同名文件处理策略（参数conflict）：
+  - overwrite: 覆盖，被覆盖的内容保存为历史版本（默认）
+  - rename:    自动重命名为 "report (1).pdf"
+  - fail:      返回409
+文件先写入目标目录下的临时文件（fsListen.TempFileSuffix），再在目录锁内检查同名并rename为正式文件名，
+同时上传同名文件时不会互相覆盖一半，读取方也不会看到写了一半的文件。
+*/
+
+const (
+	conflictOverwrite = "overwrite"
+	conflictRename    = "rename"
+	conflictFail      = "fail"
+	reasonFileExists  = "FILE_EXISTS"
+)
+
+var errFileExists = errors.New("目标位置已存在同名文件")
+
+var placeLocks sync.Map // 目录路径 => *sync.Mutex，同一目录下的放置操作串行执行
+
+func lockPlaceDir(dir string) func() {
+	l, _ := placeLocks.LoadOrStore(dir, &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// 解析冲突策略参数，空字符串为覆盖
+func parseConflict(value string) (string, error) {
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case "":
+		return conflictOverwrite, nil
+	case conflictOverwrite, conflictRename, conflictFail:
+		return value, nil
+	}
+	return "", fmt.Errorf("conflict 只能为 overwrite、rename 或 fail")
+}
+
+// 同名文件的409响应
+func sendConflictError(c *fiber.Ctx, fileName string) error {
+	return c.Status(http.StatusConflict).JSON(Reply{
+		Code: http.StatusConflict,
+		Msg:  errFileExists.Error() + ": " + fileName,
+		Data: map[string]any{
+			"reason":   reasonFileExists,
+			"fileName": fileName,
+		},
+	})
+}
+
+// 策略为fail且目标已存在时提前拒绝（最终以放置时的检查为准）
+func conflictPrecheck(destPath string, conflict string) error {
+	if _, err := os.Lstat(destPath); err == nil && conflict == conflictFail {
+		return errFileExists
+	}
+	return nil
+}
+
+// 与目标文件同目录的临时文件路径，保证最后的rename在同一文件系统内完成
+func sharedTempPath(destPath string) (string, error) {
+	id, err := randomHex(6)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(destPath), "."+filepath.Base(destPath)+"."+id+fsListen.TempFileSuffix), nil
+}
+
+// 生成不存在的文件名：report.pdf => report (1).pdf、report (2).pdf ...
+func nextAvailablePath(destPath string) string {
+	dir, name := filepath.Split(destPath)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if ext == name { // .gitignore 这类只有扩展名的文件
+		base, ext = name, ""
+	}
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		if _, err := os.Lstat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
+
+// 按冲突策略把临时文件rename到目标位置，返回最终路径
+func (r Router) placeSharedFile(token *UserToken, tempPath string, destPath string, conflict string) (string, error) {
+	unlock := lockPlaceDir(filepath.Dir(destPath))
+	defer unlock()
+	if info, err := os.Lstat(destPath); err == nil {
+		switch {
+		case conflict == conflictRename:
+			destPath = nextAvailablePath(destPath)
+		case conflict == conflictFail || !info.Mode().IsRegular(): // 同名目录无法覆盖
+			return "", errFileExists
+		default:
+			if err := r.keepVersion(token, destPath); err != nil {
+				return "", err
+			}
+		}
+	}
+	if err := os.Rename(tempPath, destPath); err != nil {
+		return "", err
+	}
+	return destPath, nil
+}
+
+// 上传会话完成时把临时文件放到共享目录；放置失败时移回会话临时文件，客户端可换策略后重新完成
+func (r Router) sharedPlacer(token *UserToken, destPath string, conflict string, placed *string) func(src string) error {
+	return func(src string) error {
+		tempPath, err := sharedTempPath(destPath)
+		if err != nil {
+			return err
+		}
+		if err := moveFile(src, tempPath); err != nil {
+			os.Remove(tempPath)
+			return fmt.Errorf("移动文件失败: %v", err)
+		}
+		final, err := r.placeSharedFile(token, tempPath, destPath, conflict)
+		if err != nil {
+			if moveErr := moveFile(tempPath, src); moveErr != nil {
+				os.Remove(tempPath)
+			}
+			return err
+		}
+		*placed = final
+		return nil
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 共享文件（/shared）、用户文件（/user）和分享链接的文件下载：
+// 支持单段Range（视频拖动、断点续传）、ETag/Last-Modified条件请求，文件名按UTF-8输出
+
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// 根据内容哈希生成强ETag，哈希未计算完成时退化为基于大小和修改时间的弱ETag
+func fileETag(hash string, info fs.FileInfo) string {
+	if hash != "" {
+		return `"` + hash + `"`
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// 判断If-Match/If-None-Match请求头是否匹配ETag，strong为true时弱ETag不参与比较
+func etagMatch(header string, etag string, strong bool) bool {
+	if strong && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// 解析单段Range请求头，返回闭区间[start, end]；格式不支持（如多段）时ok为false，按完整文件返回
+func parseByteRange(header string, size int64) (start int64, end int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" { // bytes=-n，最后n个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true, nil
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end = size - 1
+	if last != "" {
+		e, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || e < start {
+			return 0, 0, false, nil
+		}
+		if e < end {
+			end = e
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end, true, nil
+}
+
+// 解析HTTP日期请求头
+func parseHTTPTime(header string) (time.Time, bool) {
+	if header == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(header)
+	return t, err == nil
+}
+
+/*
+sendFileContent 发送单个文件：
+  - hashOf 返回文件的内容哈希（可为nil），用于生成强ETag
+  - disposition 为 inline 或 attachment
+  - 目录或不存在的文件交给后续的404处理
+*/
+func sendFileContent(c *fiber.Ctx, absPath string, disposition string, hashOf func(fs.FileInfo) string) error {
+	file, err := os.Open(absPath)
+	if err != nil {
+		return c.Next()
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return c.Next()
+	}
+	hash := ""
+	if hashOf != nil {
+		hash = hashOf(info)
+	}
+	etag := fileETag(hash, info)
+	modTime := info.ModTime().Truncate(time.Second)
+	size := info.Size()
+
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, modTime.UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderCacheControl, "private, no-cache")
+
+	// 条件请求：If-Match/If-Unmodified-Since 不满足时412，If-None-Match/If-Modified-Since 命中时304
+	if ifMatch := c.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		if !etagMatch(ifMatch, etag, true) {
+			file.Close()
+			return c.SendStatus(fiber.StatusPreconditionFailed)
+		}
+	} else if t, ok := parseHTTPTime(c.Get(fiber.HeaderIfUnmodifiedSince)); ok && modTime.After(t) {
+		file.Close()
+		return c.SendStatus(fiber.StatusPreconditionFailed)
+	}
+	notModified := false
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" {
+		notModified = etagMatch(ifNoneMatch, etag, false)
+	} else if t, ok := parseHTTPTime(c.Get(fiber.HeaderIfModifiedSince)); ok {
+		notModified = !modTime.After(t)
+	}
+	if notModified {
+		file.Close()
+		c.Status(fiber.StatusNotModified)
+		return nil
+	}
+
+	c.Type(filepath.Ext(info.Name()))
+	c.Set(fiber.HeaderContentDisposition, contentDisposition(disposition, info.Name()))
+
+	start, end := int64(0), size-1
+	status := fiber.StatusOK
+	if rangeHeader := c.Get(fiber.HeaderRange); rangeHeader != "" && ifRangeMatch(c.Get(fiber.HeaderIfRange), etag, modTime) {
+		s, e, ok, err := parseByteRange(rangeHeader, size)
+		if err != nil {
+			file.Close()
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.SendStatus(fiber.StatusRequestedRangeNotSatisfiable)
+		}
+		if ok {
+			start, end, status = s, e, fiber.StatusPartialContent
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		}
+	}
+	if start > 0 {
+		if _, err := file.Seek(start, io.SeekStart); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	length := end - start + 1
+	c.Status(status)
+	body := throttledReader{Reader: io.LimitReader(file, length), pool: downloadBandwidth, key: bandwidthKey(c)}
+	c.Context().SetBodyStream(trackedDownloadBody(c, body, file, info.Name(), length), int(length))
+	return nil
+}
+
+// If-Range 为空或与当前文件一致时Range生效，否则返回完整文件
+func ifRangeMatch(ifRange string, etag string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etagMatch(ifRange, etag, true)
+	}
+	t, ok := parseHTTPTime(ifRange)
+	return ok && modTime.Equal(t)
+}
+
+// 共享文件的内容哈希，索引中的大小、修改时间与磁盘一致时才可信
+func (r Router) sharedFileHash(relPath string) func(fs.FileInfo) string {
+	return func(info fs.FileInfo) string {
+		var hash, modTime string
+		var size int64
+		err := r.db.DB.QueryRow(`SELECT fileHash, fileSize, fileModTime FROM files WHERE relPath = ? AND isDir = 0 AND deletedAt IS NULL`, relPath).Scan(&hash, &size, &modTime)
+		if err != nil || size != info.Size() || modTime != info.ModTime().Format("2006-01-02 15:04:05") {
+			return ""
+		}
+		return hash
+	}
+}
+
+// 浏览器中默认直接打开（图片、视频、PDF等），?download=1 时作为附件下载
+func requestDisposition(c *fiber.Ctx) string {
+	if c.QueryBool("download") {
+		return "attachment"
+	}
+	return "inline"
+}
+
+// 下载共享目录中的文件：/shared/*
+func (r Router) sendSharedFile(c *fiber.Ctx) error {
+	relPath, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return c.Next()
+	}
+	absPath, err := r.resolveSharedPath(relPath)
+	if err != nil {
+		return c.Next()
+	}
+	relPath, _ = filepath.Rel(filepath.Clean(r.config.SharedDir), absPath)
+	r.trackDownload(c, uploadTargetShared, filepath.ToSlash(relPath))
+	return sendFileContent(c, absPath, requestDisposition(c), r.sharedFileHash(filepath.ToSlash(relPath)))
+}
+
+// 下载用户上传（聊天）文件：/user/*
+func (r Router) sendUserFile(c *fiber.Ctx) error {
+	relPath, err := url.PathUnescape(c.Params("*"))
+	if err != nil {
+		return c.Next()
+	}
+	absPath, err := resolveUnder(r.userDir, relPath)
+	if err != nil {
+		return c.Next()
+	}
+	r.trackDownload(c, uploadTargetUser, filepath.ToSlash(filepath.Clean(relPath)))
+	return sendFileContent(c, absPath, requestDisposition(c), nil)
+}
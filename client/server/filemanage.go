@@ -0,0 +1,404 @@
+package server
+
+import (
+	"LanDrop/client/fsListen"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 共享目录文件管理：新建文件夹、重命名、移动、复制、删除
+// 磁盘操作完成后立即同步files索引，随后到达的监听事件不会重复处理
+
+var (
+	errInvalidPath = errors.New("非法路径")
+	errPathExists  = errors.New("目标位置已存在同名文件")
+)
+
+// 将相对共享目录的路径解析为磁盘路径，拒绝 ../、绝对路径以及指向共享目录外的符号链接
+func (r Router) resolveSharedPath(relPath string) (string, error) {
+	return resolveUnder(r.config.SharedDir, relPath)
+}
+
+// 将相对路径解析为root下的绝对路径，拒绝跳出root的路径
+func resolveUnder(root string, relPath string) (string, error) {
+	relPath = strings.Trim(strings.ReplaceAll(relPath, `\`, "/"), "/")
+	for _, seg := range strings.Split(relPath, "/") {
+		if seg == ".." || strings.ContainsRune(seg, 0) {
+			return "", errInvalidPath
+		}
+	}
+	root = filepath.Clean(root)
+	absPath := filepath.Join(root, filepath.FromSlash(relPath))
+	if absPath != root && !strings.HasPrefix(absPath, root+string(os.PathSeparator)) {
+		return "", errInvalidPath
+	}
+	// 校验已存在的最深一级路径，防止通过符号链接跳出root
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	existing := absPath
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if realPath != realRoot && !strings.HasPrefix(realPath, realRoot+string(os.PathSeparator)) {
+		return "", errInvalidPath
+	}
+	return absPath, nil
+}
+
+// 校验单级文件名
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && len(name) <= 255 && !strings.ContainsAny(name, "/\\\x00")
+}
+
+// 根据fileCode获取文件及其绝对路径
+func (r Router) resolveFileCode(fileCode string) (FileInfo, string, error) {
+	f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? AND deletedAt IS NULL", fileCode))
+	if err != nil {
+		return f, "", errors.New("文件不存在: " + fileCode)
+	}
+	absPath, err := r.resolveSharedPath(f.RelPath)
+	return f, absPath, err
+}
+
+// 解析目标目录，空字符串表示共享目录根目录
+func (r Router) resolveTargetDir(relPath string) (string, error) {
+	absPath, err := r.resolveSharedPath(relPath)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil || !info.IsDir() {
+		return "", errors.New("目标目录不存在")
+	}
+	return absPath, nil
+}
+
+// 查询绝对路径对应的索引记录
+func (r Router) indexedFileInfo(absPath string) (FileInfo, error) {
+	rel, err := filepath.Rel(filepath.Clean(r.config.SharedDir), absPath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE relPath = ? AND deletedAt IS NULL", filepath.ToSlash(rel)))
+}
+
+// 复制单个文件，保留权限
+func copyFile(src string, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// 递归复制文件或目录，跳过符号链接等特殊文件
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			return copyFile(p, target, info.Mode())
+		}
+		return nil
+	})
+}
+
+// 检查目标路径是否可用
+func checkTargetFree(absPath string) error {
+	if _, err := os.Lstat(absPath); err == nil {
+		return errPathExists
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// 批量操作的失败记录
+type fileOpFailure struct {
+	FileCode string `json:"fileCode"`
+	Msg      string `json:"msg"`
+}
+
+// 新建文件夹：{path: 上级目录相对路径, name: 文件夹名}
+func (r Router) createFolder(c *fiber.Ctx) error {
+	postBody := struct {
+		Path string `json:"path"`
+		Name string `json:"name"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || !validFileName(postBody.Name) {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	parent, err := r.resolveTargetDir(postBody.Path)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	dirPath := filepath.Join(parent, postBody.Name)
+	if err := checkTargetFree(dirPath); err != nil {
+		r.Reply = Reply{
+			Code: http.StatusConflict,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := os.Mkdir(dirPath, 0755); err != nil {
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "创建文件夹失败",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := fsListen.IndexPath(r.db.DB, r.config.SharedDir, dirPath); err != nil {
+		log.Println("[x]索引新文件夹失败:", err)
+	}
+	f, _ := r.indexedFileInfo(dirPath)
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: f,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 重命名：{fileCode, newName}，保留fileCode
+func (r Router) renameFile(c *fiber.Ctx) error {
+	postBody := struct {
+		FileCode string `json:"fileCode"`
+		NewName  string `json:"newName"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.FileCode == "" || !validFileName(postBody.NewName) {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	_, src, err := r.resolveFileCode(postBody.FileCode)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	dst := filepath.Join(filepath.Dir(src), postBody.NewName)
+	if dst != src {
+		// 仅大小写不同的重命名在不区分大小写的文件系统上目标"已存在"，允许直接改名
+		if err := checkTargetFree(dst); err != nil && !strings.EqualFold(dst, src) {
+			r.Reply = Reply{
+				Code: http.StatusConflict,
+				Msg:  err.Error(),
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		if err := os.Rename(src, dst); err != nil {
+			r.Reply = Reply{
+				Code: http.StatusInternalServerError,
+				Msg:  "重命名失败",
+				Data: err.Error(),
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		if err := fsListen.MoveIndexedPath(r.db.DB, r.config.SharedDir, src, dst); err != nil {
+			log.Println("[x]更新重命名索引失败:", err)
+		}
+	}
+	f, _ := r.indexedFileInfo(dst)
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: f,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 移动或复制的公共参数：{fileCodes: [...], targetPath: 目标目录相对路径}
+type fileTransferBody struct {
+	FileCodes  []string `json:"fileCodes"`
+	TargetPath string   `json:"targetPath"`
+}
+
+// 移动/复制到目标目录，逐个处理并分别返回成功和失败的结果
+func (r Router) transferFiles(c *fiber.Ctx, copying bool) error {
+	var postBody fileTransferBody
+	if err := c.BodyParser(&postBody); err != nil || len(postBody.FileCodes) == 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	targetDir, err := r.resolveTargetDir(postBody.TargetPath)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	succeeded := []FileInfo{}
+	failed := []fileOpFailure{}
+	for _, fileCode := range postBody.FileCodes {
+		f, src, err := r.resolveFileCode(fileCode)
+		if err != nil {
+			failed = append(failed, fileOpFailure{FileCode: fileCode, Msg: err.Error()})
+			continue
+		}
+		dst := filepath.Join(targetDir, filepath.Base(src))
+		if f.IsDir && (targetDir == src || strings.HasPrefix(targetDir, src+string(os.PathSeparator))) {
+			failed = append(failed, fileOpFailure{FileCode: fileCode, Msg: "不能移动或复制到自身的子目录中"})
+			continue
+		}
+		if !copying && dst == src {
+			succeeded = append(succeeded, f)
+			continue
+		}
+		if err := checkTargetFree(dst); err != nil {
+			failed = append(failed, fileOpFailure{FileCode: fileCode, Msg: err.Error()})
+			continue
+		}
+		if copying {
+			err = copyTree(src, dst)
+			if err == nil {
+				err = fsListen.IndexPath(r.db.DB, r.config.SharedDir, dst)
+			} else {
+				os.RemoveAll(dst) // 复制失败时清理已复制的部分
+			}
+		} else {
+			err = os.Rename(src, dst)
+			if err == nil {
+				err = fsListen.MoveIndexedPath(r.db.DB, r.config.SharedDir, src, dst)
+			}
+		}
+		if err != nil {
+			log.Println("[x]移动/复制文件失败:", err)
+			failed = append(failed, fileOpFailure{FileCode: fileCode, Msg: err.Error()})
+			continue
+		}
+		if nf, err := r.indexedFileInfo(dst); err == nil {
+			succeeded = append(succeeded, nf)
+		}
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"succeeded": succeeded,
+			"failed":    failed,
+		},
+	}
+	if len(succeeded) == 0 {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "操作失败"
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 移动文件或目录，保留fileCode
+func (r Router) moveFiles(c *fiber.Ctx) error {
+	return r.transferFiles(c, false)
+}
+
+// 复制文件或目录，副本分配新的fileCode
+func (r Router) copyFiles(c *fiber.Ctx) error {
+	return r.transferFiles(c, true)
+}
+
+// 删除文件或目录（移入回收站）：{fileCodes: [...]}
+func (r Router) deleteFiles(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		FileCodes []string `json:"fileCodes"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || len(postBody.FileCodes) == 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	deleted := []string{}
+	failed := []fileOpFailure{}
+	for _, fileCode := range postBody.FileCodes {
+		f, absPath, err := r.resolveFileCode(fileCode)
+		if err == nil {
+			err = r.moveToTrash(token, f, absPath)
+		}
+		if err != nil {
+			failed = append(failed, fileOpFailure{FileCode: fileCode, Msg: err.Error()})
+			continue
+		}
+		log.Printf("%s 删除共享文件: %s", token.Username, absPath)
+		deleted = append(deleted, fileCode)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"deleted": deleted,
+			"failed":  failed,
+		},
+	}
+	if len(deleted) == 0 {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "操作失败"
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
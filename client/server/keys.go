@@ -0,0 +1,278 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+/*
+token签名密钥：每个安装首次运行时随机生成，保存在app.db同目录的 token.keys（仅当前用户可读写）
+  - token使用HS256签名，头部kid标识签名所用的密钥，不同安装之间的token互不通用
+  - 轮换密钥后新签发的token使用新密钥，旧密钥在宽限期内仍可验证旧token，宽限期结束后删除，旧token随之失效
+*/
+
+const (
+	tokenKeysFileName    = "token.keys"
+	tokenKeySize         = 32
+	defaultTokenKeyGrace = 24 * time.Hour // 轮换后旧token默认宽限时间
+	maxTokenKeyGrace     = 30 * 24 * time.Hour
+	tokenKeyTimeFormat   = "2006-01-02 15:04:05"
+)
+
+var errUnknownTokenKey = errors.New("token签名密钥不存在或已过期")
+
+type tokenKey struct {
+	KID       string `json:"kid"`
+	Key       []byte `json:"key"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt,omitempty"` // 已轮换的密钥在该时间后删除，当前密钥为空
+}
+
+// 密钥信息（不含密钥内容）
+type TokenKeyInfo struct {
+	KID       string `json:"kid"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"`
+	Current   bool   `json:"current"`
+}
+
+type tokenKeyRing struct {
+	mutex sync.RWMutex
+	path  string
+	keys  []tokenKey // 最后一个为当前签名密钥
+}
+
+var tokenKeys = &tokenKeyRing{}
+
+// 加载密钥文件，不存在时生成；文件损坏时备份后重新生成（已签发的token全部失效）
+func loadTokenKeys(path string) error {
+	tokenKeys.mutex.Lock()
+	defer tokenKeys.mutex.Unlock()
+	tokenKeys.path = path
+	tokenKeys.keys = nil
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		var stored struct {
+			Keys []tokenKey `json:"keys"`
+		}
+		if jsonErr := json.Unmarshal(data, &stored); jsonErr != nil {
+			log.Printf("[x]token密钥文件损坏，重新生成: %v", jsonErr)
+			os.Rename(path, path+".bak")
+		} else {
+			tokenKeys.keys = stored.Keys
+		}
+	}
+	changed := tokenKeys.dropExpired(time.Now())
+	if len(tokenKeys.keys) == 0 || tokenKeys.keys[len(tokenKeys.keys)-1].ExpiresAt != "" {
+		key, err := newTokenKey()
+		if err != nil {
+			return err
+		}
+		tokenKeys.keys = append(tokenKeys.keys, key)
+		changed = true
+		log.Printf("已生成token签名密钥 %s", key.KID)
+	}
+	if changed {
+		return tokenKeys.save()
+	}
+	return nil
+}
+
+func newTokenKey() (tokenKey, error) {
+	key := make([]byte, tokenKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return tokenKey{}, fmt.Errorf("生成token签名密钥失败: %v", err)
+	}
+	kid, err := randomHex(8)
+	if err != nil {
+		return tokenKey{}, err
+	}
+	return tokenKey{KID: kid, Key: key, CreatedAt: time.Now().Format(tokenKeyTimeFormat)}, nil
+}
+
+// 删除宽限期已结束的密钥，返回是否有删除
+func (r *tokenKeyRing) dropExpired(now time.Time) bool {
+	kept := r.keys[:0]
+	for _, k := range r.keys {
+		if k.ExpiresAt != "" {
+			if expiresAt, err := time.ParseInLocation(tokenKeyTimeFormat, k.ExpiresAt, time.Local); err != nil || !now.Before(expiresAt) {
+				continue
+			}
+		}
+		kept = append(kept, k)
+	}
+	changed := len(kept) != len(r.keys)
+	r.keys = kept
+	return changed
+}
+
+// 先写临时文件再替换，避免写入中断导致密钥丢失
+func (r *tokenKeyRing) save() error {
+	data, err := json.MarshalIndent(struct {
+		Keys []tokenKey `json:"keys"`
+	}{r.keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0700); err != nil {
+		return err
+	}
+	tempPath := r.path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, r.path); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Chmod(r.path, 0600)
+}
+
+// 当前签名密钥
+func (r *tokenKeyRing) current() (tokenKey, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	if len(r.keys) == 0 {
+		return tokenKey{}, errors.New("token签名密钥未初始化")
+	}
+	return r.keys[len(r.keys)-1], nil
+}
+
+// 按kid查找可用于验证的密钥，宽限期已结束的密钥不再可用
+func (r *tokenKeyRing) verifyKey(kid string) ([]byte, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, k := range r.keys {
+		if k.KID != kid {
+			continue
+		}
+		if k.ExpiresAt != "" {
+			if expiresAt, err := time.ParseInLocation(tokenKeyTimeFormat, k.ExpiresAt, time.Local); err != nil || !time.Now().Before(expiresAt) {
+				return nil, false
+			}
+		}
+		return k.Key, true
+	}
+	return nil, false
+}
+
+// 轮换签名密钥，旧密钥在grace时间内仍可验证
+func (r *tokenKeyRing) rotate(grace time.Duration) (tokenKey, error) {
+	key, err := newTokenKey()
+	if err != nil {
+		return tokenKey{}, err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	r.dropExpired(now)
+	previous := append([]tokenKey(nil), r.keys...)
+	for i := range r.keys {
+		if r.keys[i].ExpiresAt == "" {
+			r.keys[i].ExpiresAt = now.Add(grace).Format(tokenKeyTimeFormat)
+		}
+	}
+	r.keys = append(r.keys, key)
+	if err := r.save(); err != nil {
+		r.keys = previous
+		return tokenKey{}, err
+	}
+	return key, nil
+}
+
+func (r *tokenKeyRing) list() []TokenKeyInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	list := make([]TokenKeyInfo, 0, len(r.keys))
+	for i, k := range r.keys {
+		list = append(list, TokenKeyInfo{KID: k.KID, CreatedAt: k.CreatedAt, ExpiresAt: k.ExpiresAt, Current: i == len(r.keys)-1})
+	}
+	return list
+}
+
+// 验证token时按头部kid选择密钥
+func tokenKeyFunc(token *jwt.Token) (any, error) {
+	if token.Method != jwt.SigningMethodHS256 {
+		return nil, fmt.Errorf("不支持的签名算法: %v", token.Header["alg"])
+	}
+	kid, _ := token.Header["kid"].(string)
+	key, ok := tokenKeys.verifyKey(kid)
+	if !ok {
+		return nil, errUnknownTokenKey
+	}
+	return key, nil
+}
+
+// 查询签名密钥列表（仅超级管理员）
+func (r Router) getTokenKeys(c *fiber.Ctx) error {
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = tokenKeys.list()
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 轮换签名密钥（仅超级管理员），graceHours为旧token的宽限时间，返回当前用户用新密钥签发的token
+func (r Router) rotateTokenKey(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		GraceHours *int `json:"graceHours"`
+	}{}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&postBody); err != nil {
+			r.Reply.Code = http.StatusBadRequest
+			r.Reply.Msg = "请验证参数正确性"
+			r.Reply.Data = err
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+	}
+	grace := defaultTokenKeyGrace
+	if postBody.GraceHours != nil {
+		grace = time.Duration(*postBody.GraceHours) * time.Hour
+		if grace < 0 || grace > maxTokenKeyGrace {
+			r.Reply.Code = http.StatusBadRequest
+			r.Reply.Msg = fmt.Sprintf("宽限时间需在0到%d小时之间", int(maxTokenKeyGrace.Hours()))
+			r.Reply.Data = nil
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+	}
+	key, err := tokenKeys.rotate(grace)
+	if err != nil {
+		log.Println("[x]轮换token签名密钥失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "轮换密钥失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	log.Printf("token签名密钥已轮换，新密钥 %s，旧密钥宽限 %v", key.KID, grace)
+	claims := *token
+	claims.IssuedAt = jwt.NewNumericDate(time.Now())
+	newToken, err := signToken(claims)
+	if err != nil {
+		r.Reply.Code = http.StatusOK
+		r.Reply.Msg = "创建token失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{
+		"kid":   key.KID,
+		"keys":  tokenKeys.list(),
+		"token": newToken,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+点对点发送文件：文件经服务器中转直接交给接收方，不在主机上落盘
+ 1. 发送方WS发送 fileOffer {toId, fileName, fileSize, mimeType}，回复 replyFileOffer，接收方收到 fileOfferReceived
+ 2. 接收方WS发送 fileOfferAnswer {offerId, accept}，双方收到 fileOfferAccepted 或 fileOfferDeclined
+ 3. 接收方 GET /api/v1/receiveOfferFile?offerId=xx 下载，发送方 PUT /api/v1/sendOfferFile?offerId=xx 上传
+    （Content-Type: application/octet-stream），两端通过内存管道对接：接收方读得慢时发送方的上传随之变慢，
+    先到的一方等待另一方
+ 4. 传输结束双方收到 fileOfferDone；任一方可发送 fileOfferCancel {offerId} 取消，对方收到 fileOfferCanceled；
+    未应答或同意后迟迟未开始传输的请求过期后双方收到 fileOfferExpired
+传输进度通过 transferEvent 推送。
+*/
+
+const (
+	offerStatusPending      = "pending"      // 等待接收方应答
+	offerStatusAccepted     = "accepted"     // 已同意，等待双方连接
+	offerStatusTransferring = "transferring" // 传输中
+	offerStatusDone         = "done"
+	offerStatusDeclined     = "declined"
+	offerStatusCanceled     = "canceled"
+	offerStatusExpired      = "expired"
+	offerStatusFailed       = "failed"
+
+	offerAnswerTimeout  = 2 * time.Minute // 等待接收方应答的时间
+	offerConnectTimeout = 2 * time.Minute // 同意后等待双方开始传输的时间
+)
+
+var (
+	errOfferNotFound = errors.New("发送请求不存在或已结束")
+	errOfferCanceled = errors.New("发送已取消")
+	errOfferExpired  = errors.New("发送请求已过期")
+)
+
+type FileOffer struct {
+	OfferID   string `json:"offerId"`
+	FromID    int64  `json:"fromId"`
+	FromName  string `json:"fromName"`
+	ToID      int64  `json:"toId"`
+	ToName    string `json:"toName"`
+	FileName  string `json:"fileName"`
+	FileSize  int64  `json:"fileSize"`
+	MimeType  string `json:"mimeType"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"createdAt"`
+	ExpiresAt string `json:"expiresAt"` // 当前阶段的过期时间
+}
+
+type fileOffer struct {
+	FileOffer
+	mutex          sync.Mutex
+	timer          *time.Timer
+	pipeReader     *io.PipeReader
+	pipeWriter     *io.PipeWriter
+	senderAttached bool
+	receiverAttach bool
+}
+
+var fileOffers sync.Map // offerId => *fileOffer，未结束的发送请求
+
+// 通知双方（所有在线连接），content与commonReply一致
+func (o *fileOffer) notify(msgType string, data any) {
+	if wsHub == nil {
+		return
+	}
+	wsHub.sendToUsers(wsReplyData(msgType, 1, data), []int64{o.FromID, o.ToID})
+}
+
+// 当前状态的快照，用于推送和回复
+func (o *fileOffer) snapshot() FileOffer {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.FileOffer
+}
+
+// 设置当前阶段的过期时间，调用方需持有锁
+func (o *fileOffer) expireAfter(d time.Duration) {
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	o.ExpiresAt = time.Now().Add(d).Format("2006-01-02 15:04:05")
+	o.timer = time.AfterFunc(d, func() { o.finish(offerStatusExpired, errOfferExpired) })
+}
+
+// 结束发送请求并通知双方，重复结束时不做处理
+func (o *fileOffer) finish(status string, err error) {
+	if _, loaded := fileOffers.LoadAndDelete(o.OfferID); !loaded {
+		return
+	}
+	o.mutex.Lock()
+	if o.timer != nil {
+		o.timer.Stop()
+	}
+	o.Status = status
+	if err != nil { // 打断阻塞在管道上的读写
+		o.pipeReader.CloseWithError(err)
+		o.pipeWriter.CloseWithError(err)
+	}
+	o.mutex.Unlock()
+	endTransfer("p2p_"+o.OfferID, err)
+	msgType := map[string]string{
+		offerStatusDone:     "fileOfferDone",
+		offerStatusDeclined: "fileOfferDeclined",
+		offerStatusCanceled: "fileOfferCanceled",
+		offerStatusExpired:  "fileOfferExpired",
+		offerStatusFailed:   "fileOfferFailed",
+	}[status]
+	data := map[string]any{"offer": o.snapshot()}
+	if err != nil {
+		data["error"] = err.Error()
+	}
+	o.notify(msgType, data)
+}
+
+func getFileOffer(offerId string) (*fileOffer, error) {
+	if o, ok := fileOffers.Load(offerId); ok {
+		return o.(*fileOffer), nil
+	}
+	return nil, errOfferNotFound
+}
+
+// WS消息回复的序列化数据，结构与commonReply一致
+func wsReplyData(replyType string, replyCode int64, replyData any) []byte {
+	data, _ := json.Marshal(WSMsg{
+		SID:        generateClientID(),
+		Type:       replyType,
+		ClientType: "LD_APP",
+		Content: map[string]any{
+			"code": replyCode,
+			"data": replyData,
+		},
+		TimeStamp: time.Now().UnixMilli(),
+	})
+	return data
+}
+
+// 用户是否有在线的WS连接
+func userOnline(userId int64) bool {
+	wsHub.mutex.RLock()
+	defer wsHub.mutex.RUnlock()
+	for _, client := range wsHub.clients {
+		if client.Id == userId && client.IsActive {
+			return true
+		}
+	}
+	return false
+}
+
+// 创建发送请求：只能发给在线的好友
+func createFileOffer(c *WSClient, m WebMsg) (*fileOffer, error) {
+	toId, _ := m.SendData["toId"].(float64)
+	fileName, _ := m.SendData["fileName"].(string)
+	fileSize, _ := m.SendData["fileSize"].(float64)
+	mimeType, _ := m.SendData["mimeType"].(string)
+	if toId <= 0 || fileName == "" || fileSize <= 0 {
+		return nil, errors.New("请验证参数正确性")
+	}
+	if len(sg.RunQuery("queryIsFriend", c.Id, int64(toId))) == 0 {
+		return nil, fmt.Errorf("不存在的好友关系不能发送文件:%v=>%v", c.Id, int64(toId))
+	}
+	if !userOnline(int64(toId)) {
+		return nil, errors.New("对方不在线")
+	}
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	o := &fileOffer{FileOffer: FileOffer{
+		OfferID:   id,
+		FromID:    c.Id,
+		FromName:  c.UserToken.Username,
+		ToID:      int64(toId),
+		FileName:  fileName,
+		FileSize:  int64(fileSize),
+		MimeType:  mimeType,
+		Status:    offerStatusPending,
+		CreatedAt: time.Now().Format("2006-01-02 15:04:05"),
+	}}
+	c.DB.DB.QueryRow(`SELECT name FROM users WHERE id = ?`, o.ToID).Scan(&o.ToName)
+	o.pipeReader, o.pipeWriter = io.Pipe()
+	o.mutex.Lock()
+	fileOffers.Store(o.OfferID, o)
+	o.expireAfter(offerAnswerTimeout)
+	o.mutex.Unlock()
+	return o, nil
+}
+
+// 接收方应答
+func answerFileOffer(c *WSClient, offerId string, accept bool) (*fileOffer, error) {
+	o, err := getFileOffer(offerId)
+	if err != nil {
+		return nil, err
+	}
+	o.mutex.Lock()
+	if o.ToID != c.Id || o.Status != offerStatusPending {
+		o.mutex.Unlock()
+		return nil, errOfferNotFound
+	}
+	if !accept {
+		o.mutex.Unlock()
+		o.finish(offerStatusDeclined, errors.New("对方已拒绝"))
+		return o, nil
+	}
+	o.Status = offerStatusAccepted
+	o.expireAfter(offerConnectTimeout)
+	o.mutex.Unlock()
+	o.notify("fileOfferAccepted", map[string]any{"offer": o.snapshot()})
+	return o, nil
+}
+
+func initP2PFunc() {
+	// 发起点对点发送
+	FuncMap["fileOffer"] = func(c *WSClient, m WebMsg) {
+		o, err := createFileOffer(c, m)
+		if err != nil {
+			commonReply(c, m.SID, "replyFileOffer", -1, err.Error())
+			return
+		}
+		offer := o.snapshot()
+		commonReply(c, m.SID, "replyFileOffer", 1, offer)
+		wsHub.sendToUsers(wsReplyData("fileOfferReceived", 1, offer), []int64{o.ToID})
+	}
+	// 接收方同意或拒绝
+	FuncMap["fileOfferAnswer"] = func(c *WSClient, m WebMsg) {
+		offerId, _ := m.SendData["offerId"].(string)
+		accept, _ := m.SendData["accept"].(bool)
+		o, err := answerFileOffer(c, offerId, accept)
+		if err != nil {
+			commonReply(c, m.SID, "replyFileOfferAnswer", -1, err.Error())
+			return
+		}
+		commonReply(c, m.SID, "replyFileOfferAnswer", 1, o.snapshot())
+	}
+	// 任一方取消（包括传输过程中）
+	FuncMap["fileOfferCancel"] = func(c *WSClient, m WebMsg) {
+		offerId, _ := m.SendData["offerId"].(string)
+		o, err := getFileOffer(offerId)
+		if err != nil || (o.FromID != c.Id && o.ToID != c.Id) {
+			commonReply(c, m.SID, "replyFileOfferCancel", -1, errOfferNotFound.Error())
+			return
+		}
+		o.finish(offerStatusCanceled, errOfferCanceled)
+		commonReply(c, m.SID, "replyFileOfferCancel", 1, o.snapshot())
+	}
+	// 查询自己发出和收到的未结束请求（重连后恢复界面）
+	FuncMap["queryFileOffers"] = func(c *WSClient, m WebMsg) {
+		offers := []FileOffer{}
+		fileOffers.Range(func(_, value any) bool {
+			if o := value.(*fileOffer); o.FromID == c.Id || o.ToID == c.Id {
+				offers = append(offers, o.snapshot())
+			}
+			return true
+		})
+		commonReply(c, m.SID, "replyFileOffers", 1, offers)
+	}
+}
+
+// 获取当前用户作为指定角色可以连接的发送请求，并标记该端已连接
+func (r Router) attachFileOffer(c *fiber.Ctx, sender bool) (*fileOffer, error) {
+	token := c.Locals("userToken").(*UserToken)
+	o, err := getFileOffer(c.Query("offerId"))
+	if err != nil {
+		return nil, err
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	switch {
+	case sender && o.FromID != token.UserID, !sender && o.ToID != token.UserID:
+		return nil, errOfferNotFound
+	case o.Status == offerStatusPending:
+		return nil, errors.New("对方尚未同意接收")
+	case sender && o.senderAttached, !sender && o.receiverAttach:
+		return nil, errors.New("传输已在进行中")
+	}
+	if sender {
+		o.senderAttached = true
+	} else {
+		o.receiverAttach = true
+	}
+	if o.senderAttached && o.receiverAttach { // 双方都已连接，之后由传输本身决定结束
+		o.Status = offerStatusTransferring
+		o.timer.Stop()
+	}
+	return o, nil
+}
+
+// 发送方上传文件内容 PUT /sendOfferFile?offerId=xx，请求在接收方读完后返回
+func (r Router) sendOfferFile(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	o, err := r.attachFileOffer(c, true)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	ev := uploadTransfer(token, "p2p_"+o.OfferID, o.FileName, o.FileSize)
+	ev.RecipientID, ev.RecipientName, ev.IP = o.ToID, o.ToName, c.IP()
+	t := startTransfer(ev)
+	n, err := io.Copy(o.pipeWriter, progressReader{Reader: io.LimitReader(requestBodyReader(c), o.FileSize), t: t})
+	if err == nil && n != o.FileSize {
+		err = fmt.Errorf("文件不完整: %d/%d", n, o.FileSize)
+	}
+	if err != nil {
+		if !errors.Is(err, errOfferCanceled) && !errors.Is(err, errOfferExpired) {
+			log.Println("[x]点对点发送失败:", err)
+		}
+		o.finish(offerStatusFailed, err) // 已被取消或过期时不做处理
+		r.Reply = Reply{
+			Code: http.StatusConflict,
+			Msg:  err.Error(),
+			Data: o.snapshot(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	o.pipeWriter.Close()
+	o.finish(offerStatusDone, nil)
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: o.snapshot(),
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 接收方下载文件内容 GET /receiveOfferFile?offerId=xx，响应体直接读取发送方的上传数据
+func (r Router) receiveOfferFile(c *fiber.Ctx) error {
+	o, err := r.attachFileOffer(c, false)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if o.MimeType != "" {
+		c.Set(fiber.HeaderContentType, o.MimeType)
+	} else {
+		c.Type(filepath.Ext(o.FileName))
+	}
+	c.Set(fiber.HeaderContentDisposition, contentDisposition("attachment", o.FileName))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Status(fiber.StatusOK)
+	c.Context().SetBodyStream(offerBody{o}, int(o.FileSize))
+	return nil
+}
+
+// 接收方的响应体，连接关闭时若发送未完成则取消发送
+type offerBody struct {
+	o *fileOffer
+}
+
+func (b offerBody) Read(p []byte) (int, error) {
+	return b.o.pipeReader.Read(p)
+}
+
+func (b offerBody) Close() error {
+	b.o.pipeReader.CloseWithError(errors.New("接收方已断开"))
+	return nil
+}
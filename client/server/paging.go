@@ -0,0 +1,67 @@
+package server
+
+import (
+	"LanDrop/client/sqlGather"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 列表接口统一的游标分页参数：cursor（上一页返回的nextCursor）、limit、sort、order
+// 返回数据统一包含 total（总条数）和 nextCursor（为空表示没有更多数据）
+
+const maxPageLimit = 500 // 每页最大条数
+
+type pageParams struct {
+	cursor string
+	limit  int
+	sort   string
+	order  string
+}
+
+// 各列表可排序字段：参数名 -> 查询输出列名
+var (
+	fileSortFields = map[string]string{
+		"name":    "fileName",
+		"size":    "fileSize",
+		"modTime": "fileModTime",
+	}
+	userSortFields = map[string]string{
+		"id":       "id",
+		"name":     "name",
+		"nickName": "nickName",
+	}
+	chatSortFields = map[string]string{
+		"time": "time",
+	}
+)
+
+// 读取HTTP请求的分页参数，未传limit时使用defaultLimit（0表示返回全部）
+func queryPageParams(c *fiber.Ctx, defaultLimit int) pageParams {
+	return pageParams{
+		cursor: c.Query("cursor"),
+		limit:  min(c.QueryInt("limit", defaultLimit), maxPageLimit),
+		sort:   c.Query("sort"),
+		order:  c.Query("order"),
+	}
+}
+
+// 读取WebSocket消息sendData中的分页参数
+func wsPageParams(m WebMsg, defaultLimit int) pageParams {
+	p := pageParams{limit: defaultLimit}
+	p.cursor, _ = m.SendData["cursor"].(string)
+	p.sort, _ = m.SendData["sort"].(string)
+	p.order, _ = m.SendData["order"].(string)
+	if limit, ok := m.SendData["limit"].(float64); ok {
+		p.limit = min(int(limit), maxPageLimit)
+	}
+	return p
+}
+
+// 按可排序字段生成分页查询，prefix为固定在前的排序字段（例如目录优先）
+func (p pageParams) pageQuery(fields map[string]string, defaultSort string, defaultDesc bool, idColumn string, prefix ...sqlGather.SortKey) (sqlGather.PageQuery, error) {
+	keys, err := sqlGather.ParseSort(p.sort, p.order, fields, defaultSort, defaultDesc, idColumn)
+	if err != nil {
+		return sqlGather.PageQuery{}, err
+	}
+	return sqlGather.NewPageQuery(p.cursor, p.limit, append(prefix, keys...))
+}
@@ -0,0 +1,596 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+权限模型：接口和WebSocket消息按权限控制，权限分配给角色，角色保存在roles表
+  - 超级管理员角色（admin+）固定拥有全部权限，不能修改；管理角色、管理token签名密钥为超级管理员专属权限
+  - 其他角色的权限由超级管理员配置，修改后立即生效；调整账号的角色会注销该账号的会话，重新登录后生效
+  - 所有接口和WebSocket消息类型都在下方权限表中登记，由权限中间件统一校验，未登记的一律拒绝
+  - 桌面端的扫描局域网工具通过Wails调用，不经过HTTP接口，由 CheckScanNetworkPermission 按调用方token校验
+*/
+
+const (
+	superRole = "admin+" // 超级管理员
+	adminRole = "admin"
+	guestRole = "guest" // 访客，免密登录（createToken）的默认角色
+
+	permPublic = "public" // 无需登录
+	permLogin  = ""       // 登录即可
+
+	permUpload          = "upload"
+	permDownload        = "download"
+	permDelete          = "delete"
+	permShare           = "share"
+	permChat            = "chat"
+	permManageFiles     = "manageFiles"
+	permManageUsers     = "manageUsers"
+	permViewTransfers   = "viewTransfers"
+	permManageSettings  = "manageSettings"
+	permViewDeviceInfo  = "viewDeviceInfo"
+	permScanNetwork     = "scanNetwork"
+	permManageRoles     = "manageRoles"
+	permManageTokenKeys = "manageTokenKeys"
+
+	revokeReasonRoleChanged = "role_changed"
+)
+
+type Permission struct {
+	Name     string `json:"name"`
+	Label    string `json:"label"`
+	Reserved bool   `json:"reserved"` // 超级管理员专属，不能分配给其他角色
+}
+
+var permissionList = []Permission{
+	{Name: permUpload, Label: "上传文件"},
+	{Name: permDownload, Label: "浏览和下载文件"},
+	{Name: permDelete, Label: "删除和还原自己删除的文件"},
+	{Name: permShare, Label: "创建分享链接"},
+	{Name: permChat, Label: "聊天和添加好友"},
+	{Name: permManageFiles, Label: "管理共享文件（新建、重命名、移动、复制、清空回收站，管理所有人的回收站和分享链接）"},
+	{Name: permManageUsers, Label: "管理用户（查看和注销其他账号的会话、强制下线、查看其他账号的存储用量）"},
+	{Name: permViewTransfers, Label: "查看所有人的传输"},
+	{Name: permManageSettings, Label: "管理设置（上传策略、本机IP）"},
+	{Name: permViewDeviceInfo, Label: "查看设备信息"},
+	{Name: permScanNetwork, Label: "扫描局域网（桌面端工具）"},
+	{Name: permManageRoles, Label: "管理角色和账号角色", Reserved: true},
+	{Name: permManageTokenKeys, Label: "管理token签名密钥", Reserved: true},
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Label       string   `json:"label"`
+	Permissions []string `json:"permissions"`
+	Builtin     bool     `json:"builtin"` // 内置角色不能删除
+	UpdatedAt   string   `json:"updatedAt"`
+}
+
+// 内置角色的默认权限，仅在角色不存在时写入，之后以数据库为准
+var defaultRoles = []Role{
+	{Name: superRole, Label: "超级管理员"},
+	{Name: adminRole, Label: "管理员", Permissions: []string{
+		permUpload, permDownload, permDelete, permShare, permChat, permManageFiles, permManageUsers,
+		permViewTransfers, permManageSettings, permViewDeviceInfo, permScanNetwork,
+	}},
+	{Name: guestRole, Label: "访客", Permissions: []string{permUpload, permDownload, permDelete, permShare, permChat}},
+}
+
+var roleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_+-]{1,32}$`)
+
+type roleRegistry struct {
+	mutex sync.RWMutex
+	roles map[string]Role
+	perms map[string]map[string]bool
+}
+
+var roleStore = &roleRegistry{}
+
+// 写入缺少的内置角色并加载角色表
+func loadRoles(sldb db.SqlliteDB) error {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, role := range defaultRoles {
+		if _, err := sldb.Exec(`INSERT OR IGNORE INTO roles (name, label, permissions, builtin, updatedAt) VALUES (?, ?, ?, 1, ?)`,
+			role.Name, role.Label, strings.Join(role.Permissions, ","), now); err != nil {
+			return err
+		}
+	}
+	return roleStore.reload(sldb)
+}
+
+func (s *roleRegistry) reload(sldb db.SqlliteDB) error {
+	rows, err := sldb.DB.Query(`SELECT name, label, permissions, builtin, updatedAt FROM roles`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	roles := make(map[string]Role)
+	perms := make(map[string]map[string]bool)
+	for rows.Next() {
+		var role Role
+		var permissions string
+		if err := rows.Scan(&role.Name, &role.Label, &permissions, &role.Builtin, &role.UpdatedAt); err != nil {
+			return err
+		}
+		role.Permissions = []string{}
+		set := make(map[string]bool)
+		for _, p := range permissionList {
+			// 超级管理员拥有全部权限，其他角色忽略专属权限和已不存在的权限
+			if role.Name == superRole || (!p.Reserved && slices.Contains(strings.Split(permissions, ","), p.Name)) {
+				role.Permissions = append(role.Permissions, p.Name)
+				set[p.Name] = true
+			}
+		}
+		roles[role.Name] = role
+		perms[role.Name] = set
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.roles = roles
+	s.perms = perms
+	s.mutex.Unlock()
+	return nil
+}
+
+// 角色列表：内置角色在前
+func (s *roleRegistry) list() []Role {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]Role, 0, len(s.roles))
+	for _, role := range s.roles {
+		list = append(list, role)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Builtin != list[j].Builtin {
+			return list[i].Builtin
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func roleExists(role string) bool {
+	roleStore.mutex.RLock()
+	defer roleStore.mutex.RUnlock()
+	_, ok := roleStore.roles[role]
+	return ok
+}
+
+// 角色是否拥有指定权限
+func hasPermission(role string, permission string) bool {
+	roleStore.mutex.RLock()
+	defer roleStore.mutex.RUnlock()
+	return roleStore.perms[role][permission]
+}
+
+func rolePermissions(role string) []string {
+	roleStore.mutex.RLock()
+	defer roleStore.mutex.RUnlock()
+	if r, ok := roleStore.roles[role]; ok {
+		return r.Permissions
+	}
+	return []string{}
+}
+
+// actor是否拥有target的全部权限，管理其他账号（强制下线、注销会话）时不能越权操作权限更高的账号
+func coversRole(actor string, target string) bool {
+	roleStore.mutex.RLock()
+	defer roleStore.mutex.RUnlock()
+	for permission := range roleStore.perms[target] {
+		if !roleStore.perms[actor][permission] {
+			return false
+		}
+	}
+	return true
+}
+
+// 拥有管理权限的账号只能通过密码登录，免密登录只能获得访客角色
+func isPrivilegedRole(role string) bool {
+	return hasPermission(role, permManageUsers) || hasPermission(role, permManageRoles)
+}
+
+// 接口权限表：key为 "方法 路径"，路径以*结尾时按前缀匹配，HEAD请求按GET处理
+var routePermissions = map[string]string{
+	// 无需登录：登录、免密登录、刷新token
+	"POST /api/v1/getUserList":  permPublic,
+	"POST /api/v1/createUser":   permPublic,
+	"POST /api/v1/createToken":  permPublic,
+	"POST /api/v1/appLogin":     permPublic,
+	"POST /api/v1/refreshToken": permPublic,
+	"OPTIONS /tus":              permPublic,
+	"OPTIONS /tus/*":            permPublic,
+	// 账号和会话
+	"POST /api/v1/unBindUser":       permLogin,
+	"POST /api/v1/changePassword":   permLogin,
+	"POST /api/v1/logout":           permLogin,
+	"POST /api/v1/logoutAll":        permLogin,
+	"GET /api/v1/getSessions":       permLogin,
+	"POST /api/v1/terminateSession": permLogin,
+	"POST /api/v1/updateUserInfo":   permLogin,
+	"GET /api/v1/getConfigData":     permLogin,
+	"GET /api/v1/getStorageUsage":   permLogin,
+	"GET /api/v1/getPermissions":    permLogin,
+	"GET /api/v1/getRoles":          permLogin,
+	"POST /api/v1/forceLogout":      permManageUsers,
+	"POST /api/v1/saveRole":         permManageRoles,
+	"POST /api/v1/deleteRole":       permManageRoles,
+	"POST /api/v1/setUserRole":      permManageRoles,
+	"GET /api/v1/getTokenKeys":      permManageTokenKeys,
+	"POST /api/v1/rotateTokenKey":   permManageTokenKeys,
+	// 设备和网络
+	"GET /api/v1/getDeviceInfo":  permViewDeviceInfo,
+	"GET /api/v1/getNetworkInfo": permViewDeviceInfo,
+	"GET /api/v1/getWSStatus":    permViewDeviceInfo,
+	"POST /api/v1/setIpAddress":  permManageSettings,
+	// 浏览和下载
+	"GET /api/v1/getSharedDirInfo":    permDownload,
+	"GET /api/v1/thumbnail":           permDownload,
+	"GET /api/v1/searchFiles":         permDownload,
+	"GET /api/v1/getRealFilePath":     permDownload,
+	"GET /api/v1/downloadArchive":     permDownload,
+	"GET /api/v1/getArchiveProgress":  permDownload,
+	"POST /api/v1/cancelArchive":      permDownload,
+	"GET /api/v1/getFileVersions":     permDownload,
+	"GET /api/v1/downloadFileVersion": permDownload,
+	"GET /api/v1/receiveOfferFile":    permDownload,
+	"GET /shared/*":                   permDownload,
+	"GET /user/*":                     permLogin,
+	// 上传
+	"POST /api/v1/uploadFile":          permUpload,
+	"POST /api/v1/uploadChatFiles":     permUpload,
+	"POST /api/v1/initChunkUpload":     permUpload,
+	"PUT /api/v1/uploadChunk":          permUpload,
+	"GET /api/v1/getChunkUploadStatus": permUpload,
+	"POST /api/v1/finishChunkUpload":   permUpload,
+	"POST /api/v1/cancelChunkUpload":   permUpload,
+	"POST /api/v1/checkUpload":         permUpload,
+	"POST /api/v1/restoreFileVersion":  permUpload,
+	"PUT /api/v1/sendOfferFile":        permUpload,
+	"POST /tus":                        permUpload,
+	"GET /tus/*":                       permUpload,
+	"PATCH /tus/*":                     permUpload,
+	"DELETE /tus/*":                    permUpload,
+	// 上传策略配置
+	"GET /api/v1/getUploadPolicies":   permManageSettings,
+	"POST /api/v1/setUploadPolicy":    permManageSettings,
+	"POST /api/v1/deleteUploadPolicy": permManageSettings,
+	// 删除和回收站
+	"POST /api/v1/deleteFiles":  permDelete,
+	"GET /api/v1/getTrashList":  permDelete,
+	"POST /api/v1/restoreTrash": permDelete,
+	"POST /api/v1/emptyTrash":   permManageFiles,
+	// 共享目录文件管理
+	"POST /api/v1/createFolder": permManageFiles,
+	"POST /api/v1/renameFile":   permManageFiles,
+	"POST /api/v1/moveFiles":    permManageFiles,
+	"POST /api/v1/copyFiles":    permManageFiles,
+	// 分享链接
+	"POST /api/v1/createShareLink": permShare,
+	"GET /api/v1/getShareLinks":    permShare,
+	"POST /api/v1/revokeShareLink": permShare,
+	"GET /api/v1/getShareLinkLogs": permShare,
+}
+
+// WebSocket消息权限表：key为消息类型
+var wsPermissions = map[string]string{
+	"pullData":                permLogin,
+	"queryClients":            permLogin,
+	"getNotifyRedDotData":     permLogin,
+	"queryTransfers":          permLogin,
+	"addFriends":              permChat,
+	"dealWithFriendsRequest":  permChat,
+	"queryFriendList":         permChat,
+	"queryChatRecords":        permChat,
+	"changeChatRecordsStatus": permChat,
+	"chatSendData":            permChat,
+	"fileOffer":               permUpload,
+	"fileOfferAnswer":         permDownload,
+	"fileOfferCancel":         permLogin,
+	"queryFileOffers":         permLogin,
+}
+
+// 需要登记权限的路径前缀，静态资源、分享链接匿名下载等不在此列
+var protectedPrefixes = []string{"/api/", "/tus/", "/shared/", "/user/"}
+
+var routePermissionIndex = func() map[string]string {
+	index := make(map[string]string, len(routePermissions))
+	for key, permission := range routePermissions {
+		index[strings.ToLower(key)] = permission
+	}
+	return index
+}()
+
+func isProtectedPath(path string) bool {
+	path = strings.ToLower(strings.TrimSuffix(path, "/")) + "/"
+	for _, prefix := range protectedPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// 查找接口所需权限，路由不区分大小写，查找时统一转为小写
+func routePermission(method string, path string) (string, bool) {
+	if method == fiber.MethodHead {
+		method = fiber.MethodGet
+	}
+	key := strings.ToLower(method + " " + strings.TrimSuffix(path, "/"))
+	if permission, ok := routePermissionIndex[key]; ok {
+		return permission, true
+	}
+	for pattern, permission := range routePermissionIndex {
+		if strings.HasSuffix(pattern, "*") && strings.HasPrefix(key, strings.TrimSuffix(pattern, "*")) {
+			return permission, true
+		}
+	}
+	return "", false
+}
+
+// 权限中间件：按接口权限表校验当前账号的角色
+func permissionMiddleware(c *fiber.Ctx) error {
+	if !isProtectedPath(c.Path()) {
+		return c.Next()
+	}
+	permission, ok := routePermission(c.Method(), c.Path())
+	if !ok {
+		log.Printf("[x]接口【%s %s】未登记权限，拒绝访问", c.Method(), c.Path())
+		return sendForbidden(c)
+	}
+	if permission == permPublic {
+		return c.Next()
+	}
+	token, ok := c.Locals("userToken").(*UserToken)
+	if !ok {
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"code": -999,
+			"data": nil,
+			"msg":  "身份凭证过期或无效。请重新登录。",
+		})
+	}
+	if permission != permLogin && !hasPermission(token.Role, permission) {
+		return sendForbidden(c)
+	}
+	return c.Next()
+}
+
+// 桌面端扫描局域网工具（Wails绑定）不经过HTTP中间件，按调用方的登录token校验会话和扫描局域网权限
+func CheckScanNetworkPermission(tokenString string) error {
+	token, err := ParseToken(tokenString)
+	if err != nil {
+		return errors.New("身份凭证过期或无效，请重新登录")
+	}
+	if token.PwdChange {
+		return errors.New("请先修改初始密码")
+	}
+	if err := checkSession(slDB, token); err != nil {
+		return err
+	}
+	if !hasPermission(token.Role, permScanNetwork) {
+		return errors.New("没有操作权限")
+	}
+	return nil
+}
+
+func sendForbidden(c *fiber.Ctx) error {
+	return c.Status(http.StatusForbidden).JSON(Reply{
+		Code: http.StatusForbidden,
+		Msg:  "没有操作权限",
+		Data: nil,
+	})
+}
+
+// WebSocket消息权限校验，未登记的消息类型一律拒绝
+func wsMessageAllowed(c *WSClient, msgType string) bool {
+	permission, ok := wsPermissions[msgType]
+	return ok && (permission == permLogin || hasPermission(c.UserType, permission))
+}
+
+// 启动时检查所有接口和消息类型是否已登记权限，未登记的会被拒绝访问
+func checkPermissionCoverage(app *fiber.App) {
+	for _, route := range app.GetRoutes(true) {
+		if route.Method == fiber.MethodHead || !isProtectedPath(route.Path) {
+			continue
+		}
+		if _, ok := routePermission(route.Method, route.Path); !ok {
+			log.Printf("[x]接口【%s %s】未登记权限，将拒绝访问", route.Method, route.Path)
+		}
+	}
+	for msgType := range FuncMap {
+		if _, ok := wsPermissions[msgType]; !ok {
+			log.Printf("[x]消息类型【%s】未登记权限，将拒绝处理", msgType)
+		}
+	}
+}
+
+// 查询当前账号的角色和权限
+func (r Router) getPermissions(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{
+		"role":        token.Role,
+		"permissions": rolePermissions(token.Role),
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 查询角色列表和所有权限
+func (r Router) getRoles(c *fiber.Ctx) error {
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{
+		"roles":       roleStore.list(),
+		"permissions": permissionList,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 新建或修改角色（仅超级管理员），超级管理员角色不能修改，专属权限不能分配
+func (r Router) saveRole(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		Name        string   `json:"name"`
+		Label       string   `json:"label"`
+		Permissions []string `json:"permissions"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || !roleNamePattern.MatchString(postBody.Name) {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "角色名只能包含字母、数字和 _ + -，长度1到32"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if postBody.Name == superRole {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "超级管理员角色不能修改"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	label := strings.TrimSpace(postBody.Label)
+	if label == "" {
+		label = postBody.Name
+	}
+	if len([]rune(label)) > 32 {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "角色名称不能超过32个字符"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	permissions := []string{}
+	for _, name := range postBody.Permissions {
+		i := slices.IndexFunc(permissionList, func(p Permission) bool { return p.Name == name })
+		if i < 0 || permissionList[i].Reserved {
+			r.Reply.Code = http.StatusBadRequest
+			r.Reply.Msg = "权限不存在或不能分配: " + name
+			r.Reply.Data = nil
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		if !slices.Contains(permissions, name) {
+			permissions = append(permissions, name)
+		}
+	}
+	_, err := r.db.Exec(`INSERT INTO roles (name, label, permissions, builtin, updatedAt) VALUES (?, ?, ?, 0, ?)
+		ON CONFLICT(name) DO UPDATE SET label = excluded.label, permissions = excluded.permissions, updatedAt = excluded.updatedAt`,
+		postBody.Name, label, strings.Join(permissions, ","), time.Now().Format("2006-01-02 15:04:05"))
+	if err == nil {
+		err = roleStore.reload(r.db)
+	}
+	if err != nil {
+		log.Println("[x]保存角色失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "保存角色失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	log.Printf("用户【%s】保存角色【%s】，权限：%s", token.Username, postBody.Name, strings.Join(permissions, ","))
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = roleStore.list()
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 删除角色（仅超级管理员），内置角色和仍有账号使用的角色不能删除，同时删除该角色的上传策略
+func (r Router) deleteRole(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		Name string `json:"name"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.Name == "" {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "请验证参数正确性"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var builtin bool
+	if err := r.db.DB.QueryRow(`SELECT builtin FROM roles WHERE name = ?`, postBody.Name).Scan(&builtin); err != nil {
+		r.Reply.Code = http.StatusNotFound
+		r.Reply.Msg = "角色不存在"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if builtin {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "内置角色不能删除"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var users int
+	r.db.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE role = ?`, postBody.Name).Scan(&users)
+	if users > 0 {
+		r.Reply.Code = http.StatusConflict
+		r.Reply.Msg = "仍有账号使用该角色，请先调整这些账号的角色"
+		r.Reply.Data = users
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if _, err := r.db.Exec(`DELETE FROM roles WHERE name = ? AND builtin = 0`, postBody.Name); err != nil {
+		log.Println("[x]删除角色失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "删除角色失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.db.Exec(`DELETE FROM upload_policies WHERE scope = ? AND subject = ?`, policyScopeRole, postBody.Name)
+	if err := roleStore.reload(r.db); err != nil {
+		log.Println("[x]加载角色失败:", err)
+	}
+	log.Printf("用户【%s】删除角色【%s】", token.Username, postBody.Name)
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = roleStore.list()
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 调整账号的角色（仅超级管理员），不能调整自己的角色，调整后注销该账号的所有会话
+func (r Router) setUserRole(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		UserId int64  `json:"userId"`
+		Role   string `json:"role"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.UserId == 0 || !roleExists(postBody.Role) {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "请验证参数正确性"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if postBody.UserId == token.UserID {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "不能调整自己的角色"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	result, err := r.db.Exec(`UPDATE users SET role = ? WHERE id = ?`, postBody.Role, postBody.UserId)
+	if err != nil {
+		log.Println("[x]调整账号角色失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "调整角色失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		r.Reply.Code = http.StatusNotFound
+		r.Reply.Msg = "账号不存在"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	count := revokeSessions(r.db, revokeReasonRoleChanged, `userId = ?`, postBody.UserId)
+	log.Printf("用户【%s】将账号【%d】的角色调整为【%s】，注销会话 %d 个", token.Username, postBody.UserId, postBody.Role, count)
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{"revoked": count}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
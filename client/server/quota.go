@@ -0,0 +1,583 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"LanDrop/client/fsListen"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+上传策略：按全局、角色、用户三级配置存储配额、单文件大小上限以及扩展名、MIME类型的允许/禁止名单
+  - 配额和大小上限取最具体一级已配置的值（null继承上级，0不限制）
+  - 允许名单取最具体一级非空的名单（全部为空表示不限制），禁止名单各级合并
+  - 用量记录在user_files表，共享文件按fileCode关联files表，删除或移入回收站后不再计入；
+    进行中的分片/tus上传按文件大小预占配额
+  - 未配置任何策略时不做限制
+
+上传超出策略时返回明确的状态码和原因代码（Data.reason）：
+413 单文件过大、507 超出存储配额、415 文件类型不允许。
+分片和tus上传在创建会话时即可校验；表单上传在读取请求体之前按Content-Length预检，
+客户端也可以先调用 /api/v1/checkUpload 预检，或通过 X-File-Name 请求头提前声明文件名。
+*/
+
+const (
+	policyScopeGlobal = "global"
+	policyScopeRole   = "role"
+	policyScopeUser   = "user"
+
+	reasonFileTooLarge   = "FILE_TOO_LARGE"
+	reasonQuotaExceeded  = "QUOTA_EXCEEDED"
+	reasonExtBlocked     = "EXTENSION_BLOCKED"
+	reasonExtNotAllowed  = "EXTENSION_NOT_ALLOWED"
+	reasonMimeBlocked    = "MIME_BLOCKED"
+	reasonMimeNotAllowed = "MIME_NOT_ALLOWED"
+
+	multipartOverhead = 16 * 1024 // 表单上传中文件以外的边界、字段等开销估算
+)
+
+type UploadPolicy struct {
+	PolicyID     int64  `json:"policyId"`
+	Scope        string `json:"scope"`        // global、role、user
+	Subject      string `json:"subject"`      // 角色名或用户id，global为空
+	QuotaBytes   *int64 `json:"quotaBytes"`   // 存储配额，null继承上级，0不限制
+	MaxFileSize  *int64 `json:"maxFileSize"`  // 单文件大小上限，null继承上级，0不限制
+	AllowedExts  string `json:"allowedExts"`  // 允许的扩展名，逗号分隔，例如 jpg,png,pdf
+	BlockedExts  string `json:"blockedExts"`  // 禁止的扩展名
+	AllowedMimes string `json:"allowedMimes"` // 允许的MIME类型，支持 image/* 通配
+	BlockedMimes string `json:"blockedMimes"` // 禁止的MIME类型
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// 合并各级策略后对某个用户生效的策略
+type effectivePolicy struct {
+	QuotaBytes   int64    `json:"quotaBytes"`
+	MaxFileSize  int64    `json:"maxFileSize"`
+	AllowedExts  []string `json:"allowedExts"`
+	BlockedExts  []string `json:"blockedExts"`
+	AllowedMimes []string `json:"allowedMimes"`
+	BlockedMimes []string `json:"blockedMimes"`
+}
+
+// 待上传的文件
+type uploadCandidate struct {
+	FileName string
+	FileSize int64
+	MimeType string // 客户端声明的类型，为空时按扩展名推断
+	Pending  int64  // 同一请求中已通过校验、尚未保存的文件大小
+	UploadID string // 续传时排除自身会话的预占用量
+}
+
+// 上传被策略拒绝
+type policyError struct {
+	Status int
+	Reason string
+	Msg    string
+	Limit  int64 // 对应的限制值（字节），类型限制为0
+	Used   int64 // 超出配额时的已用量
+}
+
+func (e *policyError) Error() string {
+	return e.Msg
+}
+
+func (e *policyError) reply() Reply {
+	return Reply{
+		Code: e.Status,
+		Msg:  e.Msg,
+		Data: map[string]any{
+			"reason": e.Reason,
+			"limit":  e.Limit,
+			"used":   e.Used,
+		},
+	}
+}
+
+// 策略被拒绝时返回对应状态码，其他错误按500处理
+func sendPolicyError(c *fiber.Ctx, err error) error {
+	var pe *policyError
+	if errors.As(err, &pe) {
+		reply := pe.reply()
+		reply.Msg = err.Error() // 包装后的错误带有文件名等上下文
+		return c.Status(pe.Status).JSON(reply)
+	}
+	log.Println("[x]校验上传策略失败:", err)
+	return c.Status(http.StatusInternalServerError).JSON(Reply{
+		Code: http.StatusInternalServerError,
+		Msg:  "校验上传策略失败",
+		Data: nil,
+	})
+}
+
+// 解析逗号或空白分隔的名单，统一小写，扩展名去掉前导点
+func splitPolicyList(raw string, trimDot bool) []string {
+	list := []string{}
+	for _, item := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' || r == '\t' }) {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if trimDot {
+			item = strings.TrimPrefix(item, ".")
+		}
+		if item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// 规范化名单后重新拼接保存
+func normalizePolicyList(raw string, trimDot bool) string {
+	return strings.Join(splitPolicyList(raw, trimDot), ",")
+}
+
+// MIME类型匹配，支持 * 和 image/* 通配
+func matchMime(patterns []string, mimeType string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == "*/*" || p == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(p, "/*"); ok && strings.HasPrefix(mimeType, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// 去掉参数部分并转小写，例如 "text/plain; charset=utf-8" => "text/plain"
+func baseMime(mimeType string) string {
+	if mediaType, _, err := mime.ParseMediaType(mimeType); err == nil {
+		return strings.ToLower(mediaType)
+	}
+	return strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+}
+
+// 查询与用户相关的各级策略，按 全局、角色、用户 的顺序合并
+func loadEffectivePolicy(sldb db.SqlliteDB, token *UserToken) (effectivePolicy, error) {
+	p := effectivePolicy{AllowedExts: []string{}, BlockedExts: []string{}, AllowedMimes: []string{}, BlockedMimes: []string{}}
+	rows, err := sldb.DB.Query(`SELECT quotaBytes, maxFileSize, allowedExts, blockedExts, allowedMimes, blockedMimes FROM upload_policies
+		WHERE scope = ? OR (scope = ? AND subject = ?) OR (scope = ? AND subject = ?)
+		ORDER BY CASE scope WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END`,
+		policyScopeGlobal, policyScopeRole, token.Role, policyScopeUser, strconv.FormatInt(token.UserID, 10),
+		policyScopeGlobal, policyScopeRole)
+	if err != nil {
+		return p, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var quota, maxSize sql.NullInt64
+		var allowedExts, blockedExts, allowedMimes, blockedMimes string
+		if err := rows.Scan(&quota, &maxSize, &allowedExts, &blockedExts, &allowedMimes, &blockedMimes); err != nil {
+			return p, err
+		}
+		if quota.Valid {
+			p.QuotaBytes = quota.Int64
+		}
+		if maxSize.Valid {
+			p.MaxFileSize = maxSize.Int64
+		}
+		if list := splitPolicyList(allowedExts, true); len(list) > 0 {
+			p.AllowedExts = list
+		}
+		if list := splitPolicyList(allowedMimes, false); len(list) > 0 {
+			p.AllowedMimes = list
+		}
+		p.BlockedExts = append(p.BlockedExts, splitPolicyList(blockedExts, true)...)
+		p.BlockedMimes = append(p.BlockedMimes, splitPolicyList(blockedMimes, false)...)
+	}
+	return p, rows.Err()
+}
+
+/*
+storageUsage 统计用户存储用量：
+  - used: 已上传且仍存在的共享文件（按当前大小）与个人目录文件
+  - reserved: 进行中的上传会话预占的大小，excludeUploadId 为续传时排除的会话
+*/
+func storageUsage(sldb db.SqlliteDB, userId int64, excludeUploadId string) (used int64, reserved int64, err error) {
+	err = sldb.DB.QueryRow(`SELECT
+		(SELECT COALESCE(SUM(f.fileSize), 0) FROM user_files u JOIN files f ON f.fileCode = u.fileKey AND f.deletedAt IS NULL AND f.isDir = 0
+			WHERE u.userId = ? AND u.target = ?)
+		+ (SELECT COALESCE(SUM(fileSize), 0) FROM user_files WHERE userId = ? AND target = ?)`,
+		userId, uploadTargetShared, userId, uploadTargetUser).Scan(&used)
+	if err != nil {
+		return
+	}
+	err = sldb.DB.QueryRow(`SELECT COALESCE(SUM(fileSize), 0) FROM uploads WHERE userId = ? AND status = ? AND uploadId != ?`,
+		userId, uploadStatusActive, excludeUploadId).Scan(&reserved)
+	return
+}
+
+// 按扩展名和声明的MIME类型校验文件类型
+func (p effectivePolicy) checkType(fileName string, mimeType string) error {
+	ext := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+	if ext != "" && Contains(p.BlockedExts, ext) {
+		return &policyError{Status: http.StatusUnsupportedMediaType, Reason: reasonExtBlocked, Msg: fmt.Sprintf("禁止上传 .%s 类型的文件", ext)}
+	}
+	if len(p.AllowedExts) > 0 && !Contains(p.AllowedExts, ext) {
+		return &policyError{Status: http.StatusUnsupportedMediaType, Reason: reasonExtNotAllowed, Msg: fmt.Sprintf("不允许上传 .%s 类型的文件，允许的类型: %s", ext, strings.Join(p.AllowedExts, ","))}
+	}
+	if mimeType == "" || baseMime(mimeType) == "application/octet-stream" {
+		mimeType = mime.TypeByExtension(filepath.Ext(fileName))
+	}
+	mimeType = baseMime(mimeType)
+	if mimeType != "" && matchMime(p.BlockedMimes, mimeType) {
+		return &policyError{Status: http.StatusUnsupportedMediaType, Reason: reasonMimeBlocked, Msg: fmt.Sprintf("禁止上传 %s 类型的文件", mimeType)}
+	}
+	if len(p.AllowedMimes) > 0 && !matchMime(p.AllowedMimes, mimeType) {
+		return &policyError{Status: http.StatusUnsupportedMediaType, Reason: reasonMimeNotAllowed, Msg: fmt.Sprintf("不允许上传该类型的文件: %s", mimeType)}
+	}
+	return nil
+}
+
+// 校验单文件大小和存储配额
+func (p effectivePolicy) checkSize(sldb db.SqlliteDB, token *UserToken, fileSize int64, pending int64, excludeUploadId string) error {
+	if p.MaxFileSize > 0 && fileSize > p.MaxFileSize {
+		return &policyError{Status: http.StatusRequestEntityTooLarge, Reason: reasonFileTooLarge, Msg: fmt.Sprintf("文件大小超出限制（最大 %d 字节）", p.MaxFileSize), Limit: p.MaxFileSize}
+	}
+	if p.QuotaBytes <= 0 {
+		return nil
+	}
+	used, reserved, err := storageUsage(sldb, token.UserID, excludeUploadId)
+	if err != nil {
+		return err
+	}
+	if used+reserved+pending+fileSize > p.QuotaBytes {
+		return &policyError{Status: http.StatusInsufficientStorage, Reason: reasonQuotaExceeded, Msg: fmt.Sprintf("超出存储配额（已用 %d / %d 字节）", used+reserved, p.QuotaBytes), Limit: p.QuotaBytes, Used: used + reserved}
+	}
+	return nil
+}
+
+// 校验待上传文件是否符合当前用户的上传策略
+func (r Router) checkUpload(token *UserToken, u uploadCandidate) error {
+	p, err := loadEffectivePolicy(r.db, token)
+	if err != nil {
+		return err
+	}
+	if err := p.checkType(u.FileName, u.MimeType); err != nil {
+		return err
+	}
+	return p.checkSize(r.db, token, u.FileSize, u.Pending, u.UploadID)
+}
+
+// 创建分片/tus上传会话前校验策略，续传已有会话时不重复计算其预占用量
+func (r Router) checkUploadSession(token *UserToken, fileName string, fileSize int64, target string, expectedHash string, mimeType string) error {
+	existId, err := findResumableUpload(r.db, token.UserID, fileName, fileSize, target, expectedHash)
+	if err != nil {
+		return err
+	}
+	return r.checkUpload(token, uploadCandidate{FileName: fileName, FileSize: fileSize, MimeType: mimeType, UploadID: existId})
+}
+
+// 上传完成后按文件内容识别类型，只校验禁止名单（内容识别只能得到常见类型，不能用于允许名单）
+func (r Router) checkUploadContent(token *UserToken, absPath string) error {
+	f, err := os.Open(absPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.checkContentHead(token, f)
+}
+
+// 表单上传的文件在保存前按内容识别类型
+func (r Router) checkMultipartContent(token *UserToken, file *multipart.FileHeader) error {
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return r.checkContentHead(token, f)
+}
+
+func (r Router) checkContentHead(token *UserToken, src io.Reader) error {
+	p, err := loadEffectivePolicy(r.db, token)
+	if err != nil || len(p.BlockedMimes) == 0 {
+		return err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(src, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if mimeType := baseMime(http.DetectContentType(head[:n])); mimeType != "application/octet-stream" && matchMime(p.BlockedMimes, mimeType) {
+		return &policyError{Status: http.StatusUnsupportedMediaType, Reason: reasonMimeBlocked, Msg: fmt.Sprintf("禁止上传 %s 类型的文件", mimeType)}
+	}
+	return nil
+}
+
+/*
+uploadPreCheck 表单上传在读取请求体之前的预检：
+  - 按Content-Length校验单文件大小（single为true时请求只包含一个文件）和剩余配额
+  - 请求头 X-File-Name（URL编码）存在时同时校验文件类型
+*/
+func (r Router) uploadPreCheck(single bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := c.Locals("userToken").(*UserToken)
+		p, err := loadEffectivePolicy(r.db, token)
+		if err != nil {
+			return sendPolicyError(c, err)
+		}
+		if name := c.Get("X-File-Name"); name != "" {
+			if decoded, err := url.QueryUnescape(name); err == nil {
+				name = decoded
+			}
+			if err := p.checkType(name, ""); err != nil {
+				return sendPolicyError(c, err)
+			}
+		}
+		size := int64(c.Request().Header.ContentLength()) - multipartOverhead
+		if size <= 0 {
+			return c.Next()
+		}
+		if !single { // 多文件上传无法得知单个文件大小，只校验配额
+			p.MaxFileSize = 0
+		}
+		if err := p.checkSize(r.db, token, size, 0, ""); err != nil {
+			return sendPolicyError(c, err)
+		}
+		return c.Next()
+	}
+}
+
+// 记录上传到共享目录的文件，同步索引后按fileCode归属到上传用户
+func (r Router) recordSharedUpload(userId int64, absPath string) {
+	if err := fsListen.IndexPath(r.db.DB, r.config.SharedDir, absPath); err != nil {
+		log.Println("[x]索引上传文件失败:", err)
+		return
+	}
+	relPath, err := filepath.Rel(r.config.SharedDir, absPath)
+	if err != nil {
+		return
+	}
+	var fileCode string
+	var fileSize int64
+	if err := r.db.DB.QueryRow(`SELECT fileCode, fileSize FROM files WHERE relPath = ? AND deletedAt IS NULL`, filepath.ToSlash(relPath)).Scan(&fileCode, &fileSize); err != nil {
+		log.Println("[x]记录上传用量失败:", err)
+		return
+	}
+	recordUserFile(r.db, userId, uploadTargetShared, fileCode, filepath.Base(absPath), fileSize)
+}
+
+// 记录上传到个人目录的文件，fileKey为 /user/ 下的相对路径
+func (r Router) recordUserUpload(userId int64, absPath string) {
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return
+	}
+	relPath, err := filepath.Rel(r.userDir, absPath)
+	if err != nil {
+		return
+	}
+	recordUserFile(r.db, userId, uploadTargetUser, filepath.ToSlash(relPath), info.Name(), info.Size())
+}
+
+// 同一文件被覆盖上传时归属到最后上传的用户
+func recordUserFile(sldb db.SqlliteDB, userId int64, target string, fileKey string, fileName string, fileSize int64) {
+	if _, err := sldb.Exec(`INSERT INTO user_files (userId, target, fileKey, fileName, fileSize, createdAt) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (target, fileKey) DO UPDATE SET userId = excluded.userId, fileName = excluded.fileName, fileSize = excluded.fileSize, createdAt = excluded.createdAt`,
+		userId, target, fileKey, fileName, fileSize, time.Now().Format("2006-01-02 15:04:05")); err != nil {
+		log.Println("[x]记录上传用量失败:", err)
+	}
+}
+
+// 上传前预检：{fileName, fileSize, mimeType}，通过时返回当前用量
+func (r Router) checkUploadPolicy(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		FileName string `json:"fileName"`
+		FileSize int64  `json:"fileSize"`
+		MimeType string `json:"mimeType"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.FileName == "" || postBody.FileSize < 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := r.checkUpload(token, uploadCandidate{FileName: postBody.FileName, FileSize: postBody.FileSize, MimeType: postBody.MimeType}); err != nil {
+		return sendPolicyError(c, err)
+	}
+	return r.getStorageUsage(c)
+}
+
+// 查询存储用量和生效的上传策略，有管理用户权限的账号可通过userId查询其他用户
+func (r Router) getStorageUsage(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	target := token
+	if userId := int64(c.QueryInt("userId", 0)); userId != 0 && userId != token.UserID {
+		if !hasPermission(token.Role, permManageUsers) {
+			r.Reply = Reply{
+				Code: http.StatusForbidden,
+				Msg:  "没有操作权限",
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		target = &UserToken{UserID: userId}
+		if err := r.db.DB.QueryRow(`SELECT name, role FROM users WHERE id = ?`, userId).Scan(&target.Username, &target.Role); err != nil {
+			r.Reply = Reply{
+				Code: http.StatusNotFound,
+				Msg:  "用户不存在",
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+	}
+	p, err := loadEffectivePolicy(r.db, target)
+	if err != nil {
+		return sendPolicyError(c, err)
+	}
+	used, reserved, err := storageUsage(r.db, target.UserID, "")
+	if err != nil {
+		return sendPolicyError(c, err)
+	}
+	remaining := int64(-1) // -1表示不限制
+	if p.QuotaBytes > 0 {
+		remaining = max(p.QuotaBytes-used-reserved, 0)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"userId":    target.UserID,
+			"used":      used,
+			"reserved":  reserved,
+			"remaining": remaining,
+			"policy":    p,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 获取所有上传策略（管理设置权限）
+func (r Router) getUploadPolicies(c *fiber.Ctx) error {
+	rows, err := r.db.DB.Query(`SELECT policyId, scope, subject, quotaBytes, maxFileSize, allowedExts, blockedExts, allowedMimes, blockedMimes, COALESCE(updatedAt, '')
+		FROM upload_policies ORDER BY CASE scope WHEN ? THEN 0 WHEN ? THEN 1 ELSE 2 END, subject`, policyScopeGlobal, policyScopeRole)
+	if err != nil {
+		return sendPolicyError(c, err)
+	}
+	defer rows.Close()
+	policies := []UploadPolicy{}
+	for rows.Next() {
+		var p UploadPolicy
+		var quota, maxSize sql.NullInt64
+		if err := rows.Scan(&p.PolicyID, &p.Scope, &p.Subject, &quota, &maxSize, &p.AllowedExts, &p.BlockedExts, &p.AllowedMimes, &p.BlockedMimes, &p.UpdatedAt); err != nil {
+			log.Printf("扫描行失败: %v", err)
+			continue
+		}
+		if quota.Valid {
+			p.QuotaBytes = &quota.Int64
+		}
+		if maxSize.Valid {
+			p.MaxFileSize = &maxSize.Int64
+		}
+		policies = append(policies, p)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: policies,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 新增或更新上传策略（管理设置权限），同一scope和subject只有一条策略
+func (r Router) setUploadPolicy(c *fiber.Ctx) error {
+	var p UploadPolicy
+	badRequest := func(msg string) error {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  msg,
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := c.BodyParser(&p); err != nil {
+		return badRequest("请验证参数正确性")
+	}
+	p.Subject = strings.TrimSpace(p.Subject)
+	switch p.Scope {
+	case policyScopeGlobal:
+		p.Subject = ""
+	case policyScopeRole:
+		if !roleExists(p.Subject) {
+			return badRequest("角色不存在: " + p.Subject)
+		}
+	case policyScopeUser:
+		var exists int
+		if r.db.DB.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, p.Subject).Scan(&exists); exists == 0 {
+			return badRequest("用户不存在: " + p.Subject)
+		}
+	default:
+		return badRequest("scope 只能为 global、role 或 user")
+	}
+	if (p.QuotaBytes != nil && *p.QuotaBytes < 0) || (p.MaxFileSize != nil && *p.MaxFileSize < 0) {
+		return badRequest("配额和文件大小不能为负数")
+	}
+	p.AllowedExts = normalizePolicyList(p.AllowedExts, true)
+	p.BlockedExts = normalizePolicyList(p.BlockedExts, true)
+	p.AllowedMimes = normalizePolicyList(p.AllowedMimes, false)
+	p.BlockedMimes = normalizePolicyList(p.BlockedMimes, false)
+	p.UpdatedAt = time.Now().Format("2006-01-02 15:04:05")
+	if err := r.db.DB.QueryRow(`INSERT INTO upload_policies (scope, subject, quotaBytes, maxFileSize, allowedExts, blockedExts, allowedMimes, blockedMimes, updatedAt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (scope, subject) DO UPDATE SET quotaBytes = excluded.quotaBytes, maxFileSize = excluded.maxFileSize, allowedExts = excluded.allowedExts,
+			blockedExts = excluded.blockedExts, allowedMimes = excluded.allowedMimes, blockedMimes = excluded.blockedMimes, updatedAt = excluded.updatedAt
+		RETURNING policyId`,
+		p.Scope, p.Subject, p.QuotaBytes, p.MaxFileSize, p.AllowedExts, p.BlockedExts, p.AllowedMimes, p.BlockedMimes, p.UpdatedAt).Scan(&p.PolicyID); err != nil {
+		log.Println("[x]保存上传策略失败:", err)
+		return badRequest("保存上传策略失败")
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: p,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 删除上传策略（管理设置权限），删除后由上级策略生效
+func (r Router) deleteUploadPolicy(c *fiber.Ctx) error {
+	postBody := struct {
+		PolicyID int64 `json:"policyId"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.PolicyID == 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	res, err := r.db.Exec(`DELETE FROM upload_policies WHERE policyId = ?`, postBody.PolicyID)
+	if err != nil {
+		return sendPolicyError(c, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  "上传策略不存在",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: nil,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
@@ -2,9 +2,11 @@ package server
 
 import (
 	"LanDrop/client/db"
+	"LanDrop/client/sqlGather"
 	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
 	"log"
 	"mime/multipart"
@@ -31,12 +33,19 @@ func startRouter(app *fiber.App, assets embed.FS, config Config, sldb db.Sqllite
 	wsHub = NewWSHub(ctx, sldb)
 	go wsHub.Run()
 	r := Router{
-		app:     app,
-		assets:  assets,
-		config:  config,
-		db:      sldb,
-		userDir: userDir,
-	}
+		app:         app,
+		assets:      assets,
+		config:      config,
+		db:          sldb,
+		userDir:     userDir,
+		tempDir:     createDir(AppDir, "uploads"),  // 分片上传临时目录
+		trashDir:    createDir(AppDir, "trash"),    // 回收站目录
+		versionsDir: createDir(AppDir, "versions"), // 共享文件历史版本目录
+	}
+	go cleanExpiredUploads(sldb)
+	startSessionCleaner(sldb)
+	startTrashPurger(sldb, r.trashDir, r.versionsDir)
+	startThumbnailer()
 	// WebSocket 升级中间件
 	app.Use("/ws", func(c *fiber.Ctx) error {
 		if websocket.IsWebSocketUpgrade(c) {
@@ -60,7 +69,16 @@ func startRouter(app *fiber.App, assets embed.FS, config Config, sldb db.Sqllite
 			sendErrorAndClose(conn, "无法验证token有效性")
 			return
 		}
-		if tokenJWT.Role != "admin+" && tokenJWT.Role != "admin" && tokenJWT.Role != "guest" { // 验证角色权限 - 修复逻辑判断
+		if tokenJWT.PwdChange {
+			sendErrorAndClose(conn, "请先修改初始密码")
+			return
+		}
+		if err := checkSession(sldb, tokenJWT); err != nil { // 会话已注销
+			sendErrorAndClose(conn, "登录会话已失效")
+			return
+		}
+		touchSession(sldb, tokenJWT.SessionID, conn.IP())
+		if !roleExists(tokenJWT.Role) { // 角色不存在（已删除）
 			sendErrorAndClose(conn, "token角色验证失败")
 			return
 		}
@@ -85,9 +103,11 @@ func startRouter(app *fiber.App, assets embed.FS, config Config, sldb db.Sqllite
 		// 获取设备信息
 		api.Get("/getDeviceInfo", r.getDeviceInfo)
 		// 上传文件到shared目录
-		api.Post("/uploadFile", r.uploadFile)
+		api.Post("/uploadFile", r.uploadPreCheck(true), r.uploadFile)
 		// 获取共享目录信息
 		api.Get("/getSharedDirInfo", r.getSharedDirInfo)
+		api.Get("/thumbnail", r.getThumbnail)  // 图片缩略图
+		api.Get("/searchFiles", r.searchFiles) // 搜索共享文件
 		// 通过fileId 获取真实路径
 		api.Get("/getRealFilePath", r.getRealFilePath)
 		// 获取所有网卡信息包括ipv4 v6地址
@@ -104,6 +124,15 @@ func startRouter(app *fiber.App, assets embed.FS, config Config, sldb db.Sqllite
 		api.Post("/unBindUser", r.unBindUser)
 		// 客户端登录
 		api.Post("/appLogin", r.appLogin)
+		// 修改密码（首次登录必须修改默认密码）
+		api.Post("/changePassword", r.changePassword)
+		// 登录会话：刷新token、退出登录、退出所有设备
+		api.Post("/refreshToken", r.refreshToken)
+		api.Post("/logout", r.logout)
+		api.Post("/logoutAll", r.logoutAll)
+		// 登录设备：查询会话列表、注销指定会话（有管理用户权限时可查看和注销其他账号的会话）
+		api.Get("/getSessions", r.getSessions)
+		api.Post("/terminateSession", r.terminateSession)
 		// websocket状态
 		api.Get("/getWSStatus", r.getWSStatus)
 		// 获取配置信息
@@ -111,8 +140,74 @@ func startRouter(app *fiber.App, assets embed.FS, config Config, sldb db.Sqllite
 		// 更新用户信息
 		api.Post("/updateUserInfo", r.updateUserInfo)
 		// 上传用户聊天文件例如图片、文件
-		api.Post("/uploadChatFiles", r.uploadChatFiles)
-	}
+		api.Post("/uploadChatFiles", r.uploadPreCheck(false), r.uploadChatFiles)
+		// 分片上传：初始化、上传分片、查询进度、完成、取消
+		api.Post("/initChunkUpload", r.initChunkUpload)
+		api.Put("/uploadChunk", r.uploadChunk)
+		api.Get("/getChunkUploadStatus", r.getChunkUploadStatus)
+		api.Post("/finishChunkUpload", r.finishChunkUpload)
+		api.Post("/cancelChunkUpload", r.cancelChunkUpload)
+		// 文件夹/多文件打包下载：下载、查询进度、取消
+		api.Get("/downloadArchive", r.downloadArchive)
+		api.Get("/getArchiveProgress", r.getArchiveProgress)
+		api.Post("/cancelArchive", r.cancelArchive)
+		// 分享链接：创建、列表、撤销、下载记录
+		api.Post("/createShareLink", r.createShareLink)
+		api.Get("/getShareLinks", r.getShareLinks)
+		api.Post("/revokeShareLink", r.revokeShareLink)
+		api.Get("/getShareLinkLogs", r.getShareLinkLogs)
+		// 共享目录文件管理：新建文件夹、重命名、移动、复制
+		api.Post("/createFolder", r.createFolder)
+		api.Post("/renameFile", r.renameFile)
+		api.Post("/moveFiles", r.moveFiles)
+		api.Post("/copyFiles", r.copyFiles)
+		// 删除（移入回收站，可还原）、回收站列表、还原、清空
+		api.Post("/deleteFiles", r.deleteFiles)
+		api.Get("/getTrashList", r.getTrashList)
+		api.Post("/restoreTrash", r.restoreTrash)
+		api.Post("/emptyTrash", r.emptyTrash)
+		// 共享文件历史版本：列表、下载、还原
+		api.Get("/getFileVersions", r.getFileVersions)
+		api.Get("/downloadFileVersion", r.downloadFileVersion)
+		api.Post("/restoreFileVersion", r.restoreFileVersion)
+		// 上传策略：预检、查询用量，策略配置
+		api.Post("/checkUpload", r.checkUploadPolicy)
+		api.Get("/getStorageUsage", r.getStorageUsage)
+		api.Get("/getUploadPolicies", r.getUploadPolicies)
+		api.Post("/setUploadPolicy", r.setUploadPolicy)
+		api.Post("/deleteUploadPolicy", r.deleteUploadPolicy)
+		// 强制下线：注销指定账号的所有会话
+		api.Post("/forceLogout", r.forceLogout)
+		// token签名密钥：查询、轮换
+		api.Get("/getTokenKeys", r.getTokenKeys)
+		api.Post("/rotateTokenKey", r.rotateTokenKey)
+		// 角色权限：当前账号的权限、角色列表，新建修改删除角色、调整账号角色
+		api.Get("/getPermissions", r.getPermissions)
+		api.Get("/getRoles", r.getRoles)
+		api.Post("/saveRole", r.saveRole)
+		api.Post("/deleteRole", r.deleteRole)
+		api.Post("/setUserRole", r.setUserRole)
+		// 点对点发送文件：发送方上传、接收方下载，经内存中转不落盘
+		api.Put("/sendOfferFile", r.sendOfferFile)
+		api.Get("/receiveOfferFile", r.receiveOfferFile)
+	}
+	// 共享文件和用户文件下载，支持Range和条件请求
+	r.app.Get("/shared/*", r.sendSharedFile)
+	r.app.Get("/user/*", r.sendUserFile)
+	// 分享链接匿名访问（无需token，由链接自身的有效期、次数和密码控制）
+	r.app.Get("/s/:code/info", r.getShareInfo)
+	r.app.Get("/s/:code", r.downloadShare)
+	// tus 1.0 协议上传，兼容标准tus客户端
+	tus := r.app.Group("/tus", tusMiddleware)
+	{
+		tus.Options("/", r.tusOptions)
+		tus.Options("/:uploadId", r.tusOptions)
+		tus.Post("/", r.tusCreate)
+		tus.Head("/:uploadId", r.tusHead)
+		tus.Patch("/:uploadId", r.tusPatch)
+		tus.Delete("/:uploadId", r.tusDelete)
+	}
+	checkPermissionCoverage(r.app)
 }
 
 // --- 控制器函数 ---
@@ -137,8 +232,9 @@ func (r Router) getDeviceInfo(c *fiber.Ctx) error {
 }
 
 func (r Router) uploadFile(c *fiber.Ctx) error {
-	file, err := c.FormFile("file")
-	if err != nil {
+	token := c.Locals("userToken").(*UserToken)
+	form, err := parseMultipartForm(c)
+	if err != nil || len(form.File["file"]) == 0 {
 		r.Reply = Reply{
 			Code: http.StatusBadRequest,
 			Msg:  "failed",
@@ -146,7 +242,45 @@ func (r Router) uploadFile(c *fiber.Ctx) error {
 		}
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
-	if err := c.SaveFile(file, filepath.Join(r.config.SharedDir, file.Filename)); err != nil {
+	defer form.RemoveAll()
+	file := form.File["file"][0]
+	conflict, err := parseConflict(formValue(form, "conflict"))
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := r.checkUpload(token, uploadCandidate{FileName: file.Filename, FileSize: file.Size, MimeType: file.Header.Get("Content-Type")}); err != nil {
+		return sendPolicyError(c, err)
+	}
+	savePath := filepath.Join(r.config.SharedDir, file.Filename)
+	if err := conflictPrecheck(savePath, conflict); err != nil {
+		return sendConflictError(c, file.Filename)
+	}
+	if err := r.checkMultipartContent(token, file); err != nil {
+		return sendPolicyError(c, err)
+	}
+	if expectedHash := formValue(form, "sha256"); expectedHash != "" { // 客户端提供哈希时先校验文件完整性
+		if err := verifyMultipartHash(file, expectedHash); err != nil {
+			r.Reply = Reply{
+				Code: http.StatusBadRequest,
+				Msg:  "文件校验失败",
+				Data: err.Error(),
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+	}
+	t := r.trackFormUpload(c, form, file.Filename, file.Size)
+	tempPath, err := sharedTempPath(savePath)
+	if err == nil {
+		err = c.SaveFile(file, tempPath)
+	}
+	if err != nil {
+		t.end(err)
+		os.Remove(tempPath)
 		log.Println("Save Error:", err)
 		r.Reply = Reply{
 			Code: http.StatusInternalServerError,
@@ -155,18 +289,75 @@ func (r Router) uploadFile(c *fiber.Ctx) error {
 		}
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
+	if savePath, err = r.placeSharedFile(token, tempPath, savePath, conflict); err != nil {
+		t.end(err)
+		os.Remove(tempPath)
+		if errors.Is(err, errFileExists) {
+			return sendConflictError(c, file.Filename)
+		}
+		log.Println("Save Error:", err)
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "Failed saved file.",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	t.end(nil)
+	r.recordSharedUpload(token.UserID, savePath)
 	r.Reply = Reply{
 		Code: http.StatusOK,
 		Msg:  "successed",
 		Data: map[string]any{
-			"fileName": file.Filename,
+			"fileName": filepath.Base(savePath),
 		},
 	}
 	return c.Status(r.Reply.Code).JSON(r.Reply)
 }
 
+// 获取共享目录信息，支持通过parentId或path（相对路径）浏览子目录，默认返回根目录
 func (r Router) getSharedDirInfo(c *fiber.Ctx) error {
-	rows, err := r.db.DB.Query("SELECT * FROM files")
+	var current *FileInfo // 当前浏览的目录，根目录为nil
+	parentId := int64(c.QueryInt("parentId", 0))
+	if dirPath := strings.Trim(c.Query("path"), "/"); dirPath != "" {
+		f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE relPath = ? AND deletedAt IS NULL", dirPath))
+		if err != nil || !f.IsDir {
+			r.Reply = Reply{
+				Code: http.StatusNotFound,
+				Msg:  "目录不存在",
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		current = &f
+		parentId = int64(f.ID)
+	} else if parentId != 0 {
+		f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileId = ? AND deletedAt IS NULL", parentId))
+		if err != nil || !f.IsDir {
+			r.Reply = Reply{
+				Code: http.StatusNotFound,
+				Msg:  "目录不存在",
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		current = &f
+	}
+	// 目录始终排在文件前面，其后按sort字段排序
+	pq, err := queryPageParams(c, 0).pageQuery(fileSortFields, "name", false, "fileId", sqlGather.SortKey{Column: "isDir", Desc: true})
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	query := "SELECT " + fileInfoColumns + " FROM files WHERE parentId = ? AND deletedAt IS NULL"
+	total := 0
+	r.db.DB.QueryRow(sqlGather.CountSQL(query), parentId).Scan(&total)
+	pageSQL, args := pq.Build(query, parentId)
+	rows, err := r.db.DB.Query(pageSQL, args...)
 	if err != nil {
 		r.Reply = Reply{
 			Code: http.StatusBadRequest,
@@ -177,10 +368,9 @@ func (r Router) getSharedDirInfo(c *fiber.Ctx) error {
 	}
 	defer rows.Close()
 
-	var files []FileInfo // 假设有一个File结构体对应表结构
+	files := []FileInfo{}
 	for rows.Next() {
-		var f FileInfo
-		scanErr := rows.Scan(&f.ID, &f.Name, &f.Size, &f.Mode, &f.ModTime, &f.IsDir, &f.URIName, &f.Path, &f.FileCode)
+		f, scanErr := scanFileInfo(rows)
 		if scanErr != nil {
 			log.Printf("扫描行失败: %v", scanErr)
 			continue
@@ -196,12 +386,28 @@ func (r Router) getSharedDirInfo(c *fiber.Ctx) error {
 		}
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
+	nextCursor := ""
+	if n, more := pq.Trim(len(files)); more {
+		files = files[:n]
+		last := files[n-1]
+		isDir := 0
+		if last.IsDir {
+			isDir = 1
+		}
+		nextCursor = pq.Cursor(func(column string) any {
+			return map[string]any{"isDir": isDir, "fileName": last.Name, "fileSize": last.Size, "fileModTime": last.ModTime, "fileId": last.ID}[column]
+		})
+	}
 	r.Reply = Reply{
 		Code: http.StatusOK,
 		Msg:  "successed",
 		Data: map[string]any{
-			"sharedDir": r.config.SharedDir,
-			"files":     files,
+			"sharedDir":  r.config.SharedDir,
+			"parentId":   parentId,
+			"current":    current,
+			"files":      files,
+			"total":      total,
+			"nextCursor": nextCursor,
 		},
 	}
 	return c.Status(r.Reply.Code).JSON(r.Reply)
@@ -209,10 +415,8 @@ func (r Router) getSharedDirInfo(c *fiber.Ctx) error {
 
 func (r Router) getRealFilePath(c *fiber.Ctx) error {
 	fileCode := c.Query("fileCode")
-	var f FileInfo
-	row := r.db.DB.QueryRow("SELECT * FROM files WHERE fileCode = ?", fileCode)
-	row.Scan(&f.ID, &f.Name, &f.Size, &f.Mode, &f.ModTime, &f.IsDir, &f.URIName, &f.Path, &f.FileCode)
-	if row.Err() != nil {
+	f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? AND deletedAt IS NULL", fileCode))
+	if err != nil {
 		r.Reply.Code = 199
 		r.Reply.Msg = "query failed."
 	} else {
@@ -277,34 +481,23 @@ func (r Router) getUserList(c *fiber.Ctx) error {
 		r.Reply.Data = err
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
-	rows, err := r.db.DB.Query(`SELECT * FROM "users" WHERE ip = ? AND role= 'guest'`, clientIP)
+	pq, err := queryPageParams(c, 0).pageQuery(userSortFields, "id", false, "id")
+	if err != nil {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = err.Error()
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	userPage, err := sg.QueryPage(`SELECT id, avatar, name, nickName, role, ip, createdAt FROM "users" WHERE ip = ? AND role= 'guest'`, pq, clientIP)
 	if err != nil {
 		r.Reply.Code = http.StatusInternalServerError
 		r.Reply.Msg = "服务器错误"
 		r.Reply.Data = err
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
-	columns, _ := rows.Columns()
-	values := make([]any, len(columns))
-	for i := range values {
-		var v any
-		values[i] = &v
-	}
-	var userList []map[string]any
-	for rows.Next() {
-		if err := rows.Scan(values...); err != nil {
-			log.Printf("扫描行失败: %v", err)
-			continue
-		}
-		row := make(map[string]any)
-		for i, col := range columns {
-			row[col] = *(values[i].(*any))
-		}
-		userList = append(userList, row)
-	}
 	r.Reply.Code = http.StatusOK
 	r.Reply.Msg = "查询成功"
-	r.Reply.Data = userList
+	r.Reply.Data = userPage
 	return c.Status(r.Reply.Code).JSON(r.Reply)
 }
 
@@ -337,7 +530,22 @@ func (r Router) createUser(c *fiber.Ctx) error {
 		r.Reply.Data = nil
 		return c.Status(http.StatusOK).JSON(r.Reply)
 	}
-	result, err := r.db.DB.Exec(`INSERT INTO users (name, nickName, pwd, role, ip, createdAt) VALUES (?, ?, ?, ?, ?, ?);`, generateName(), postBody["userName"], postBody["userName"]+"#123", "guest", clientIP, time.Now().Format("2006-01-02 15:04:05"))
+	// 访客通过createToken按设备换取token，不使用密码登录，保存随机密码的哈希
+	randomPwd, err := randomHex(16)
+	if err != nil {
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "创建失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	pwdHash, err := db.HashPassword(randomPwd)
+	if err != nil {
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "创建失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	result, err := r.db.DB.Exec(`INSERT INTO users (name, nickName, pwd, role, ip, createdAt) VALUES (?, ?, ?, ?, ?, ?);`, generateName(), postBody["userName"], pwdHash, guestRole, clientIP, time.Now().Format("2006-01-02 15:04:05"))
 	if err != nil {
 		r.Reply.Code = http.StatusBadRequest
 		r.Reply.Msg = "创建失败"
@@ -355,8 +563,9 @@ func (r Router) createUser(c *fiber.Ctx) error {
 
 func (r Router) createToken(c *fiber.Ctx) error {
 	postBody := struct {
-		UserId   int64  `json:"userId"`
-		UserName string `json:"userName"`
+		UserId     int64  `json:"userId"`
+		UserName   string `json:"userName"`
+		DeviceName string `json:"deviceName"` // 可选，登录设备名称
 	}{}
 	if err := c.BodyParser(&postBody); err != nil {
 		r.Reply.Code = http.StatusBadRequest
@@ -371,16 +580,25 @@ func (r Router) createToken(c *fiber.Ctx) error {
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
 	var id int64
-	var name string
-	err := r.db.DB.QueryRow(`SELECT id, name FROM users WHERE id = ? AND name = ?`, postBody.UserId, postBody.UserName).Scan(&id, &name)
-	if err != nil && err != sql.ErrNoRows {
+	var name, role string
+	err := r.db.DB.QueryRow(`SELECT id, name, role FROM users WHERE id = ? AND name = ?`, postBody.UserId, postBody.UserName).Scan(&id, &name, &role)
+	if err != nil {
 		r.Reply.Code = http.StatusOK
 		r.Reply.Msg = "确保数据正确"
 		r.Reply.Data = err
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
-	token, err := CreateToken("guest", id, name, r.config.TokenExpiryTime)
+	// 访客会话的刷新token有效期为配置的token有效时间（小时）
+	refreshTTL := time.Duration(r.config.TokenExpiryTime) * time.Hour
+	if refreshTTL <= 0 {
+		refreshTTL = 24 * time.Hour
+	}
+	if isPrivilegedRole(role) { // 管理账号需要密码登录，免密登录只获得访客权限
+		role = guestRole
+	}
+	pair, err := createSession(r.db, role, id, name, refreshTTL, sessionClient(c, postBody.DeviceName))
 	if err != nil {
+		log.Println("[x]创建登录会话失败:", err)
 		r.Reply.Code = http.StatusOK
 		r.Reply.Msg = "创建token失败"
 		r.Reply.Data = nil
@@ -389,17 +607,11 @@ func (r Router) createToken(c *fiber.Ctx) error {
 	r.Reply.Code = http.StatusOK
 	r.Reply.Msg = "完成"
 	r.Reply.Data = map[string]any{
-		"token": token,
-	}
-	c.Cookie(&fiber.Cookie{
-		Name:     "ldtoken",
-		Value:    token,
-		Path:     "/",
-		Expires:  time.Now().Add(time.Duration(r.config.TokenExpiryTime) * time.Hour),
-		HTTPOnly: true,
-		Secure:   false,
-		SameSite: "Lax",
-	})
+		"token":        pair.Token,
+		"refreshToken": pair.RefreshToken,
+		"expiresIn":    pair.ExpiresIn,
+	}
+	setTokenCookie(c, pair.Token)
 	return c.Status(r.Reply.Code).JSON(r.Reply)
 }
 
@@ -434,6 +646,9 @@ func (r Router) unBindUser(c *fiber.Ctx) error {
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
 	affectedId, _ := result.RowsAffected()
+	if affectedId > 0 {
+		revokeSessions(r.db, revokeReasonUnbind, `userId = ?`, token.UserID)
+	}
 	r.Reply.Code = http.StatusOK
 	r.Reply.Msg = "完成"
 	r.Reply.Data = affectedId
@@ -462,8 +677,19 @@ func (r Router) appLogin(c *fiber.Ctx) error {
 	}
 	// var adminId int64
 	// var adminName, adminRole, nickName string
-	adminUserList := r.db.QueryList("SELECT id, name, nickName, role, avatar FROM users WHERE (name = ? OR id = ?) AND pwd = ?", postBody["adminName"], postBody["adminName"], postBody["adminPassword"])
-	// err := r.db.DB.QueryRow("SELECT id, name, nickName, role FROM users WHERE (nickName = ? OR name = ?) AND pwd = ?", postBody["adminName"], postBody["adminName"], postBody["adminPassword"]).Scan(&adminId, &adminName, &nickName, &adminRole)
+	// 按账号查询后逐个校验密码哈希，账号不存在时也做一次比对，避免通过耗时判断账号是否存在
+	candidates := r.db.QueryList("SELECT id, name, nickName, role, avatar, pwd, mustChangePwd FROM users WHERE (name = ? OR id = ?)", postBody["adminName"], postBody["adminName"])
+	adminUserList := []map[string]any{}
+	for _, user := range candidates {
+		pwdHash, _ := user["pwd"].(string)
+		delete(user, "pwd")
+		if db.CheckPassword(pwdHash, postBody["adminPassword"]) {
+			adminUserList = append(adminUserList, user)
+		}
+	}
+	if len(candidates) == 0 {
+		db.CheckPassword("", postBody["adminPassword"])
+	}
 	if len(adminUserList) != 1 {
 		r.Reply.Code = http.StatusBadRequest
 		r.Reply.Msg = "管理员账号或密码错误"
@@ -483,8 +709,17 @@ func (r Router) appLogin(c *fiber.Ctx) error {
 	adminUser := adminUserList[0]
 	adminId := adminUser["id"].(int64)
 	r.db.Exec(`UPDATE users SET ip = ? WHERE id = ?`, clientIP, adminId)
-	token, err := CreateToken(adminUser["role"].(string), adminId, adminUser["name"].(string), 100*365*24) // 设置app端token长期有效100年
+	mustChangePwd, _ := adminUser["mustChangePwd"].(int64)
+	var pair TokenPair
+	var err error
+	if mustChangePwd == 1 { // 默认密码登录，只签发修改密码用的临时token
+		pair.Token, err = CreatePwdChangeToken(adminUser["role"].(string), adminId, adminUser["name"].(string))
+		pair.ExpiresIn = int64(pwdChangeTokenExpiry.Seconds())
+	} else {
+		pair, err = createSession(r.db, adminUser["role"].(string), adminId, adminUser["name"].(string), appRefreshTokenExpiry, sessionClient(c, postBody["deviceName"]))
+	}
 	if err != nil {
+		log.Println("[x]创建登录会话失败:", err)
 		r.Reply.Code = http.StatusOK
 		r.Reply.Msg = "创建token失败"
 		r.Reply.Data = nil
@@ -493,13 +728,99 @@ func (r Router) appLogin(c *fiber.Ctx) error {
 	r.Reply.Code = http.StatusOK
 	r.Reply.Msg = "完成"
 	r.Reply.Data = map[string]any{
-		"token":     token,
-		"adminId":   adminId,
-		"adminName": adminUser["name"],
-		"nickName":  adminUser["nickName"],
-		"role":      adminUser["role"],
-		"avatar":    adminUser["avatar"],
+		"token":         pair.Token,
+		"refreshToken":  pair.RefreshToken,
+		"expiresIn":     pair.ExpiresIn,
+		"adminId":       adminId,
+		"adminName":     adminUser["name"],
+		"nickName":      adminUser["nickName"],
+		"role":          adminUser["role"],
+		"avatar":        adminUser["avatar"],
+		"mustChangePwd": mustChangePwd == 1,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 修改当前账号密码，成功后签发新的token
+func (r Router) changePassword(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		OldPassword string `json:"oldPassword"`
+		NewPassword string `json:"newPassword"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "请验证参数正确性"
+		r.Reply.Data = err
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if postBody.OldPassword == "" || postBody.NewPassword == "" {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "缺少必要参数"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := db.ValidatePassword(postBody.NewPassword); err != nil {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = err.Error()
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if postBody.NewPassword == postBody.OldPassword {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "新密码不能与原密码相同"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var pwdHash, role, name string
+	if err := r.db.DB.QueryRow(`SELECT pwd, role, name FROM users WHERE id = ?`, token.UserID).Scan(&pwdHash, &role, &name); err != nil {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "账号不存在"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if !db.CheckPassword(pwdHash, postBody.OldPassword) {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "原密码错误"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
+	newHash, err := db.HashPassword(postBody.NewPassword)
+	if err != nil {
+		log.Println("[x]密码哈希失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "修改密码失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	// 以原哈希为条件更新，避免并发修改时覆盖
+	result, err := r.db.Exec(`UPDATE users SET pwd = ?, mustChangePwd = 0 WHERE id = ? AND pwd = ?`, newHash, token.UserID, pwdHash)
+	if err != nil {
+		log.Println("[x]修改密码失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "修改密码失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		r.Reply.Code = http.StatusConflict
+		r.Reply.Msg = "密码已被修改，请重新登录"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	// 修改密码后注销该账号的所有会话，重新创建当前设备的会话
+	revokeSessions(r.db, revokeReasonPassword, `userId = ?`, token.UserID)
+	pair, err := createSession(r.db, role, token.UserID, name, appRefreshTokenExpiry, sessionClient(c, ""))
+	if err != nil {
+		log.Println("[x]创建登录会话失败:", err)
+		r.Reply.Code = http.StatusOK
+		r.Reply.Msg = "创建token失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = pair
 	return c.Status(r.Reply.Code).JSON(r.Reply)
 }
 
@@ -549,24 +870,37 @@ func (r Router) updateUserInfo(c *fiber.Ctx) error {
 
 func (r Router) uploadChatFiles(c *fiber.Ctx) error {
 	token := c.Locals("userToken").(*UserToken)
-	form, err := c.MultipartForm()
+	form, err := parseMultipartForm(c)
 	if err != nil {
 		r.Reply.Code = http.StatusBadRequest
 		r.Reply.Msg = "上传失败,无法解析表单数据"
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
+	defer form.RemoveAll()
 	files := form.File["files"] // 支持多文件上传
 	if len(files) == 0 {
 		r.Reply.Code = http.StatusBadRequest
 		r.Reply.Msg = "未上传任何文件"
 		return c.Status(r.Reply.Code).JSON(r.Reply)
 	}
+	// 校验上传策略，同一请求中的多个文件合计计入配额
+	var pending int64
+	for _, f := range files {
+		if err := r.checkUpload(token, uploadCandidate{FileName: f.Filename, FileSize: f.Size, MimeType: f.Header.Get("Content-Type"), Pending: pending}); err != nil {
+			return sendPolicyError(c, fmt.Errorf("%s: %w", f.Filename, err))
+		}
+		if err := r.checkMultipartContent(token, f); err != nil {
+			return sendPolicyError(c, fmt.Errorf("%s: %w", f.Filename, err))
+		}
+		pending += f.Size
+	}
 	// 2. 并发处理上传（使用 goroutine 池）
 	type uploadResult struct {
-		Name string `json:"name"`
-		URL  string `json:"url"`
-		Size int64  `json:"size"`
-		Err  error  `json:"err"`
+		Name      string `json:"name"`
+		URL       string `json:"url"`
+		Thumbnail string `json:"thumbnail,omitempty"` // 图片缩略图地址
+		Size      int64  `json:"size"`
+		Err       error  `json:"err"`
 	}
 	results := make(chan uploadResult, len(files))
 	var wg sync.WaitGroup
@@ -583,13 +917,8 @@ func (r Router) uploadChatFiles(c *fiber.Ctx) error {
 				Name: f.Filename,
 				Size: f.Size,
 			}
-			// 3. 安全校验
-			// if !isAllowedFileType(f.Filename) {
-			//     result.Err = fmt.Errorf("禁止的文件类型: %s", filepath.Ext(f.Filename))
-			//     results <- result
-			//     return
-			// }
-			// 4. 生成唯一文件名（避免冲突）
+			// 3. 生成唯一文件名（避免冲突），文件类型和大小已在上面按上传策略校验
+
 			newFilename := generateFilename(f.Filename)
 			userDir := fmt.Sprintf("%v/%v", time.Now().Format("2006_01"), token.Username)
 			savePath := filepath.Join(r.userDir, userDir)
@@ -599,14 +928,20 @@ func (r Router) uploadChatFiles(c *fiber.Ctx) error {
 				results <- result
 				return
 			}
-			// 5. 保存文件
+			// 4. 保存文件
+			t := r.trackFormUpload(c, form, f.Filename, f.Size)
 			if err := c.SaveFile(f, filepath.Join(savePath, newFilename)); err != nil {
+				t.end(err)
 				result.Err = fmt.Errorf("文件保存失败: %v", err)
 				results <- result
 				return
 			}
-			// 6. 返回可访问的 URL（生产环境替换为 CDN 地址）
+			t.end(nil)
+			r.recordUserUpload(token.UserID, filepath.Join(savePath, newFilename))
+			// 5. 返回可访问的 URL（生产环境替换为 CDN 地址）
 			result.URL = fmt.Sprintf("/user/%s/%s", userDir, newFilename)
+			result.Thumbnail = userThumbnailURL(result.URL)
+			go thumbs.prepareUserFile(filepath.Join(savePath, newFilename))
 			results <- result
 		}(file)
 	}
@@ -615,7 +950,7 @@ func (r Router) uploadChatFiles(c *fiber.Ctx) error {
 		wg.Wait()
 		close(results)
 	}()
-	// 7. 收集结果
+	// 6. 收集结果
 	var successFiles []uploadResult
 	var errorMessages []string
 	for res := range results {
@@ -625,7 +960,7 @@ func (r Router) uploadChatFiles(c *fiber.Ctx) error {
 			successFiles = append(successFiles, res)
 		}
 	}
-	// 8. 统一响应
+	// 7. 统一响应
 	if len(errorMessages) > 0 {
 		r.Reply.Code = http.StatusPartialContent
 		r.Reply.Msg = fmt.Sprintf("部分文件上传失败: %s", strings.Join(errorMessages, "; "))
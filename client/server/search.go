@@ -0,0 +1,243 @@
+package server
+
+import (
+	"LanDrop/client/fsListen"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 共享文件搜索：关键字走FTS5全文检索（文件名、扩展名，可选小文本文件内容），
+// 不足3个字符的关键字或未启用FTS5时按文件名LIKE匹配；支持大小、修改时间、类型过滤以及排序分页
+
+const (
+	defaultSearchPageSize = 50
+	maxSearchPageSize     = 200
+)
+
+var fileCategories = map[string][]string{ // 按扩展名划分的文件类型
+	"image":    {"jpg", "jpeg", "png", "gif", "webp", "bmp", "svg", "ico", "tif", "tiff", "heic"},
+	"video":    {"mp4", "mkv", "mov", "avi", "wmv", "flv", "webm", "m4v"},
+	"audio":    {"mp3", "wav", "flac", "aac", "ogg", "m4a", "wma", "ape"},
+	"document": {"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "txt", "md", "csv", "rtf", "odt", "ods", "odp", "wps"},
+	"archive":  {"zip", "rar", "7z", "tar", "gz", "tgz", "bz2", "xz", "iso"},
+}
+
+var searchSortColumns = map[string]string{
+	"name":    "fileName",
+	"size":    "fileSize",
+	"modTime": "fileModTime",
+}
+
+type SearchResult struct {
+	FileInfo
+	Snippet string `json:"snippet,omitempty"` // 内容匹配片段，仅搜索文件内容时返回
+}
+
+// 在扫描FileInfo字段之后追加扫描内容片段
+type snippetScanner struct {
+	rows    *sql.Rows
+	snippet *string
+}
+
+func (s snippetScanner) Scan(dest ...any) error {
+	return s.rows.Scan(append(dest, s.snippet)...)
+}
+
+// 转义LIKE中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// FTS5查询中的短语需用双引号包裹，内部双引号转义为两个
+func ftsPhrase(term string) string {
+	return `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+}
+
+// 解析日期参数，支持 2006-01-02 和 2006-01-02 15:04:05；只有日期时endOfDay决定取当天开始还是结束
+func parseSearchTime(value string, endOfDay bool) (string, error) {
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local); err == nil {
+		return t.Format("2006-01-02 15:04:05"), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return "", fmt.Errorf("时间格式错误: %s", value)
+	}
+	if endOfDay {
+		return t.Format("2006-01-02") + " 23:59:59", nil
+	}
+	return t.Format("2006-01-02 15:04:05"), nil
+}
+
+/*
+searchFiles 搜索共享文件：
+  - q: 关键字，空格分隔多个关键字（同时满足）；content=1 时同时搜索文本文件内容
+  - path: 只搜索该目录下；type: dir、file、image、video、audio、document、archive；ext: 扩展名，逗号分隔
+  - minSize/maxSize: 文件大小（字节）；modifiedAfter/modifiedBefore: 修改时间
+  - sort: relevance、name、size、modTime；order: asc、desc；page、pageSize: 分页
+*/
+func (r Router) searchFiles(c *fiber.Ctx) error {
+	badRequest := func(msg string) error {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  msg,
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	from := "files"
+	snippetColumn := "''"
+	where := []string{"deletedAt IS NULL"}
+	var args []any
+
+	// 关键字
+	var phrases []string
+	for _, term := range strings.Fields(c.Query("q")) {
+		if fsListen.SearchEnabled() && utf8.RuneCountInString(term) >= 3 { // trigram分词至少需要3个字符
+			phrases = append(phrases, ftsPhrase(term))
+		} else {
+			where = append(where, `fileName LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+	}
+	if len(phrases) > 0 {
+		columns := "{name ext}"
+		if c.QueryBool("content") {
+			columns = "{name ext content}"
+			snippetColumn = "snippet(files_fts, 2, '', '', '…', 48)"
+		}
+		from = "files JOIN files_fts ON files_fts.rowid = files.fileId"
+		where = append(where, "files_fts MATCH ?")
+		args = append(args, columns+": ("+strings.Join(phrases, " AND ")+")")
+	}
+
+	// 过滤条件
+	if dirPath := strings.Trim(c.Query("path"), "/"); dirPath != "" {
+		where = append(where, `relPath LIKE ? ESCAPE '\'`)
+		args = append(args, escapeLike(dirPath)+"/%")
+	}
+	var exts []string
+	switch fileType := c.Query("type"); fileType {
+	case "":
+	case "dir":
+		where = append(where, "isDir = 1")
+	case "file":
+		where = append(where, "isDir = 0")
+	default:
+		category, ok := fileCategories[fileType]
+		if !ok {
+			return badRequest("不支持的文件类型: " + fileType)
+		}
+		exts = append(exts, category...)
+	}
+	for _, ext := range strings.Split(c.Query("ext"), ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	if len(exts) > 0 {
+		conds := make([]string, len(exts))
+		for i, ext := range exts {
+			conds[i] = `lower(fileName) LIKE ? ESCAPE '\'`
+			args = append(args, "%."+escapeLike(ext))
+		}
+		where = append(where, "isDir = 0 AND ("+strings.Join(conds, " OR ")+")")
+	}
+	if minSize := c.QueryInt("minSize", -1); minSize >= 0 {
+		where = append(where, "isDir = 0 AND fileSize >= ?")
+		args = append(args, minSize)
+	}
+	if maxSize := c.QueryInt("maxSize", -1); maxSize >= 0 {
+		where = append(where, "isDir = 0 AND fileSize <= ?")
+		args = append(args, maxSize)
+	}
+	if after := c.Query("modifiedAfter"); after != "" {
+		t, err := parseSearchTime(after, false)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		where = append(where, "fileModTime >= ?")
+		args = append(args, t)
+	}
+	if before := c.Query("modifiedBefore"); before != "" {
+		t, err := parseSearchTime(before, true)
+		if err != nil {
+			return badRequest(err.Error())
+		}
+		where = append(where, "fileModTime <= ?")
+		args = append(args, t)
+	}
+
+	// 排序：有全文匹配时默认按相关度
+	sort := c.Query("sort")
+	if sort == "" || (sort == "relevance" && len(phrases) == 0) {
+		sort = "name"
+		if len(phrases) > 0 {
+			sort = "relevance"
+		}
+	}
+	var orderBy string
+	if sort == "relevance" {
+		orderBy = "bm25(files_fts), fileName ASC"
+	} else {
+		column, ok := searchSortColumns[sort]
+		if !ok {
+			return badRequest("不支持的排序字段: " + sort)
+		}
+		order := strings.ToUpper(c.Query("order", "asc"))
+		if order != "ASC" && order != "DESC" {
+			return badRequest("order 只能为 asc 或 desc")
+		}
+		orderBy = column + " " + order + ", fileId ASC"
+	}
+
+	// 分页
+	page := max(c.QueryInt("page", 1), 1)
+	pageSize := c.QueryInt("pageSize", defaultSearchPageSize)
+	if pageSize <= 0 || pageSize > maxSearchPageSize {
+		pageSize = defaultSearchPageSize
+	}
+
+	whereClause := strings.Join(where, " AND ")
+	var total int
+	if err := r.db.DB.QueryRow("SELECT COUNT(*) FROM "+from+" WHERE "+whereClause, args...).Scan(&total); err != nil {
+		log.Println("[x]搜索文件失败:", err)
+		return badRequest("搜索失败")
+	}
+	rows, err := r.db.DB.Query("SELECT "+fileInfoColumns+", "+snippetColumn+" FROM "+from+" WHERE "+whereClause+" ORDER BY "+orderBy+" LIMIT ? OFFSET ?",
+		append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		log.Println("[x]搜索文件失败:", err)
+		return badRequest("搜索失败")
+	}
+	defer rows.Close()
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		f, err := scanFileInfo(snippetScanner{rows: rows, snippet: &res.Snippet})
+		if err != nil {
+			log.Printf("扫描行失败: %v", err)
+			continue
+		}
+		res.FileInfo = f
+		results = append(results, res)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"total":    total,
+			"page":     page,
+			"pageSize": pageSize,
+			"fullText": fsListen.SearchEnabled(), // 是否启用了全文检索
+			"files":    results,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
@@ -33,6 +33,7 @@ var (
 	serverMutex sync.Mutex         // 服务器状态锁
 	shutdownCtx context.Context    // 上下文
 	cancelFunc  context.CancelFunc // 取消函数
+	watchCancel context.CancelFunc // 停止共享目录监听
 	slDB        db.SqlliteDB       // sqlite数据库
 	AppDir      string             // 进程所在目录
 	AppErr      error              // 错误信息
@@ -58,6 +59,15 @@ type Config struct {
 	SharedDir       string `json:"sharedDir"`
 	Version         string `json:"version"`
 	TokenExpiryTime int    `json:"tokenExpiryTime"`
+	// 回收站保留天数，0表示不自动清理
+	TrashRetentionDays int `json:"trashRetentionDays"`
+	// 每个共享文件保留的历史版本数，0表示不保留
+	MaxFileVersions int `json:"maxFileVersions"`
+	// 传输限速（KB/s），0表示不限速：全局上传、全局下载、单用户上传、单用户下载
+	UploadRateLimit       int `json:"uploadRateLimit"`
+	DownloadRateLimit     int `json:"downloadRateLimit"`
+	UserUploadRateLimit   int `json:"userUploadRateLimit"`
+	UserDownloadRateLimit int `json:"userDownloadRateLimit"`
 }
 
 // 检查端口是否占用
@@ -94,7 +104,10 @@ func GetSettingInfo() Config {
 		Version:         "",
 		TokenExpiryTime: 0,
 	}
-	err := slDB.DB.QueryRow(`SELECT appName, port,sharedDir ,version, tokenExpiryTime FROM settings WHERE name = 'config'`).Scan(&d.AppName, &d.Port, &d.SharedDir, &d.Version, &d.TokenExpiryTime)
+	err := slDB.DB.QueryRow(`SELECT appName, port,sharedDir ,version, tokenExpiryTime, trashRetentionDays, maxFileVersions,
+		uploadRateLimit, downloadRateLimit, userUploadRateLimit, userDownloadRateLimit FROM settings WHERE name = 'config'`).
+		Scan(&d.AppName, &d.Port, &d.SharedDir, &d.Version, &d.TokenExpiryTime, &d.TrashRetentionDays, &d.MaxFileVersions,
+			&d.UploadRateLimit, &d.DownloadRateLimit, &d.UserUploadRateLimit, &d.UserDownloadRateLimit)
 	if err != nil && err == sql.ErrNoRows {
 		sharedDir := createDir(AppDir, "shared") // 创建默认分享目录
 		d.AppName = "LanDrop"
@@ -102,6 +115,7 @@ func GetSettingInfo() Config {
 		d.SharedDir = sharedDir
 		d.Version = "V1.0.0"
 		d.TokenExpiryTime = 24
+		d.TrashRetentionDays = 30
 		nowDate := time.Now().Format("2006-01-02 15:04:05")
 		if _, err := slDB.DB.Exec(`INSERT INTO settings (name, appName, port, sharedDir, version, tokenExpiryTime, createdAt, modifiedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, "config", d.AppName, d.Port, d.SharedDir, d.Version, d.TokenExpiryTime, nowDate, nowDate); err != nil {
 			log.Println("插入配置失败", err)
@@ -112,9 +126,13 @@ func GetSettingInfo() Config {
 	return d
 }
 
-// 更新分享目录信息
+// 更新分享目录信息，限速等运行时配置立即生效
 func UpdateDirInfo(updateFields []string, updateValues []any) (sql.Result, error) {
-	return slDB.DB.Exec(fmt.Sprintf(`UPDATE settings SET %v WHERE name = 'config'`, strings.Join(updateFields, ",")), updateValues...)
+	result, err := slDB.DB.Exec(fmt.Sprintf(`UPDATE settings SET %v WHERE name = 'config'`, strings.Join(updateFields, ",")), updateValues...)
+	if err == nil {
+		applyBandwidthLimits(GetSettingInfo())
+	}
+	return result, err
 }
 
 // 启动服务器
@@ -125,12 +143,29 @@ func Run(assets embed.FS) {
 		log.Printf("数据库初始化失败: %v", AppErr)
 		return
 	}
+	// 加载token签名密钥，首次运行时生成
+	if err := loadTokenKeys(filepath.Join(AppDir, tokenKeysFileName)); err != nil {
+		log.Printf("token签名密钥初始化失败: %v", err)
+		return
+	}
+	// 加载角色和权限
+	if err := loadRoles(slDB); err != nil {
+		log.Printf("角色权限初始化失败: %v", err)
+		return
+	}
 	// 加载配置文件通过数据库
 	config := GetSettingInfo()
+	applyBandwidthLimits(config)
 	// 创建聊天用户上传的文件
 	userDir := createDir(AppDir, "user")
 	// 启动监听目录【使用goroutine避免阻塞进程】
-	go fsListen.FSWatcher(config.SharedDir, slDB.DB)
+	// 重启时先停止旧的监听，避免两个监听同时写files表
+	if watchCancel != nil {
+		watchCancel()
+	}
+	var watchCtx context.Context
+	watchCtx, watchCancel = context.WithCancel(context.Background())
+	go fsListen.FSWatcher(watchCtx, config.SharedDir, slDB.DB)
 	if !isPortAvailable(config.Port) {
 		log.Println(fmt.Printf("端口 %v 已被占用，跳过 Fiber 启动", config.Port))
 		return
@@ -140,6 +175,8 @@ func Run(assets embed.FS) {
 		Prefork:      false,                  // 启用多核并行处理
 		ErrorHandler: errorHandler,           // 全局错误处理
 		BodyLimit:    5 * 1024 * 1024 * 1024, // 最大支持500MB
+		// 流式读取请求体，分片/tus上传直接写入磁盘，避免大文件整体读入内存
+		StreamRequestBody: true,
 	})
 	// 完全跨域允许
 	// app.Use(cors.New())
@@ -186,9 +223,17 @@ func Run(assets embed.FS) {
 	}))
 
 	// 中间件：请求日志
+	// 请求体中含有密码、刷新token的接口不记录请求体（路由不区分大小写，按小写路径匹配）
+	credentialPaths := map[string]bool{
+		"/api/v1/applogin":        true,
+		"/api/v1/changepassword":  true,
+		"/api/v1/refreshtoken":    true,
+		"/api/v1/createsharelink": true,
+	}
 	app.Use(func(c *fiber.Ctx) error {
 		contentType := c.Get("Content-Type")
-		if strings.Contains(contentType, "multipart/form-data") { // 避免文件上传二进制被写入日志
+		if strings.Contains(contentType, "multipart/form-data") || strings.Contains(contentType, "octet-stream") || // 避免文件上传二进制被写入日志
+			credentialPaths[strings.ToLower(strings.TrimRight(c.Path(), "/"))] {
 			log.Printf("[%s]-|%s | %s\n", c.Method(), c.Path(), c.IP())
 			return c.Next()
 		}
@@ -196,12 +241,17 @@ func Run(assets embed.FS) {
 		return c.Next()
 	})
 
-	skipPrefixes := []string{"/", "/assets/", "/#/", "/shared/",
+	// /shared/ 需要登录访问，匿名用户通过分享链接 /s/<code> 下载
+	skipPrefixes := []string{"/", "/assets/", "/#/", "/s/",
 		"/ws", "/api/v1/getUserList", "/api/v1/createToken",
-		"/api/v1/createUser", "/api/v1/appLogin",
+		"/api/v1/createUser", "/api/v1/appLogin", "/api/v1/refreshToken",
 	}
 
 	app.Use(func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodOptions && strings.HasPrefix(c.Path(), "/tus") { // tus协议能力发现无需token
+			c.Locals("skipToken", true)
+			return c.Next()
+		}
 		for _, prefix := range skipPrefixes {
 			if prefix == "/" {
 				if c.Path() == "/" { // 严格匹配根路径
@@ -218,20 +268,31 @@ func Run(assets embed.FS) {
 
 	// JWT中间件配置
 	jwtConfig := jwtware.Config{
-		SigningKey:  jwtware.SigningKey{Key: SecretKey},
+		KeyFunc:     tokenKeyFunc, // 按token头部kid选择本机的签名密钥
 		TokenLookup: "query:token,cookie:ldtoken,header:X-Ld-Token",
 		ContextKey:  "user",
 		Claims:      &UserToken{},
-		TokenProcessorFunc: func(encryptedToken string) (string, error) { // 对加密token进行解密然后进行下一步
-			decryptedToken, err := DecryptToken(encryptedToken)
-			if err != nil {
-				return "", fmt.Errorf("token解密失败: %v", err)
-			}
-			return decryptedToken, nil
-		},
 		SuccessHandler: func(c *fiber.Ctx) error { // 验证成功后，将用户信息存储在Context中
 			user := c.Locals("user").(*jwt.Token)
 			claims := user.Claims.(*UserToken)
+			if claims.PwdChange && c.Path() != "/api/v1/changePassword" { // 首次登录未修改密码，只能访问修改密码接口
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+					"code": -998,
+					"data": nil,
+					"msg":  "请先修改初始密码。",
+				})
+			}
+			if err := checkSession(slDB, claims); err != nil { // 会话已注销（退出登录、强制下线等）
+				if c.Locals("skipToken") == true {
+					return c.Next()
+				}
+				return c.Status(401).JSON(fiber.Map{
+					"code": -999,
+					"data": nil,
+					"msg":  "身份凭证过期或无效。请重新登录。",
+				})
+			}
+			touchSession(slDB, claims.SessionID, requestIP(c))
 			c.Locals("userToken", claims)
 			return c.Next()
 		},
@@ -249,6 +310,8 @@ func Run(assets embed.FS) {
 
 	// 应用JWT中间件
 	app.Use(jwtware.New(jwtConfig))
+	// 权限中间件：按权限表校验接口访问权限
+	app.Use(permissionMiddleware)
 
 	// 将wails前端资源挂载到根路径下，wails静态资源会在应用启动时候读取加载到内存中虚拟目录。
 	app.Use("/", filesystem.New(filesystem.Config{
@@ -256,10 +319,6 @@ func Run(assets embed.FS) {
 		PathPrefix: "frontend/dist", // 匹配嵌入的路径
 		Browse:     true,            // 允许目录浏览（可选）
 	}))
-	app.Static("shared", config.SharedDir)
-
-	app.Static("user", userDir)
-
 	// 启动路由组
 	startRouter(app, assets, config, slDB, userDir)
 
@@ -314,6 +373,10 @@ func Stop() {
 		proxyApp = nil
 	}
 
+	if watchCancel != nil {
+		watchCancel()
+		watchCancel = nil
+	}
 	cancelFunc() // 通知所有阻塞的goroutine退出
 	log.Println("服务已停止")
 }
@@ -0,0 +1,576 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+登录会话：登录后签发短期访问token和刷新token，会话保存在sessions表
+  - 会话记录设备名称、IP、UA、首次登录和最后活跃时间、当前访问token的ID，用户可查看和注销自己的会话，有管理用户权限时可查看和注销所有会话
+  - 访问token有效期15分钟，携带会话ID（sid），每次请求和WebSocket连接都会校验会话是否已注销
+  - 刷新token只保存哈希，每次刷新都会更换；已被替换的刷新token再次使用视为泄露，注销整个会话
+    （刷新后短时间内的重复使用按并发刷新处理，不注销）
+  - 注销会话（退出登录、退出所有设备、管理员强制下线、修改密码、解绑账号）立即生效，已连接的WebSocket同时断开
+*/
+
+const (
+	accessTokenExpiry      = 15 * time.Minute
+	appRefreshTokenExpiry  = 30 * 24 * time.Hour // 客户端登录的刷新token有效期，每次刷新顺延
+	refreshReuseGrace      = 30 * time.Second    // 刷新后旧刷新token的容忍时间，避免多个页面同时刷新导致会话被注销
+	sessionRetention       = 30 * 24 * time.Hour // 已过期、已注销的会话保留时间
+	sessionTimeFormat      = "2006-01-02 15:04:05"
+	sessionRevokedWSType   = "sessionRevoked"
+	revokeReasonLogout     = "logout"
+	revokeReasonLogoutAll  = "logout_all"
+	revokeReasonForced     = "forced"
+	revokeReasonPassword   = "password_changed"
+	revokeReasonUnbind     = "unbind"
+	revokeReasonTokenReuse = "refresh_token_reuse"
+	revokeReasonTerminated = "terminated"
+	sessionTouchInterval   = time.Minute // 最后活跃时间的更新间隔，避免每个请求都写库
+)
+
+var (
+	errSessionRequired = errors.New("token未关联登录会话")
+	errSessionRevoked  = errors.New("登录会话已失效")
+)
+
+type TokenPair struct {
+	Token        string `json:"token"`        // 访问token
+	RefreshToken string `json:"refreshToken"` // 刷新token
+	ExpiresIn    int64  `json:"expiresIn"`    // 访问token有效秒数
+}
+
+// 登录设备信息
+type SessionClient struct {
+	DeviceName string
+	IP         string
+	UserAgent  string
+}
+
+// 请求来源的设备信息，未传设备名称时按UA生成
+func sessionClient(c *fiber.Ctx, deviceName string) SessionClient {
+	client := SessionClient{DeviceName: strings.TrimSpace(deviceName), IP: requestIP(c), UserAgent: c.Get(fiber.HeaderUserAgent)}
+	if client.DeviceName == "" {
+		client.DeviceName = deviceNameFromUA(client.UserAgent)
+	}
+	return client
+}
+
+// 客户端IP。只有来自本机代理（回环地址）的请求才取X-Forwarded-For中的第一个（最原始客户端IP），
+// 其他客户端可以任意伪造该请求头
+func requestIP(c *fiber.Ctx) string {
+	ip := c.IP()
+	if forwardedFor := c.Get("X-Forwarded-For"); forwardedFor != "" {
+		if parsed := net.ParseIP(ip); parsed != nil && parsed.IsLoopback() {
+			return strings.TrimSpace(strings.Split(forwardedFor, ",")[0])
+		}
+	}
+	return ip
+}
+
+// 按UA粗略识别设备：系统 + 浏览器
+func deviceNameFromUA(ua string) string {
+	system := ""
+	for _, s := range []struct{ key, name string }{
+		{"iPhone", "iPhone"}, {"iPad", "iPad"}, {"Android", "Android"}, {"Windows", "Windows"},
+		{"Mac OS X", "macOS"}, {"Macintosh", "macOS"}, {"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, s.key) {
+			system = s.name
+			break
+		}
+	}
+	browser := ""
+	for _, b := range []struct{ key, name string }{
+		{"Edg/", "Edge"}, {"MicroMessenger", "微信"}, {"Chrome/", "Chrome"}, {"Firefox/", "Firefox"}, {"Safari/", "Safari"},
+	} {
+		if strings.Contains(ua, b.key) {
+			browser = b.name
+			break
+		}
+	}
+	if name := strings.TrimSpace(system + " " + browser); name != "" {
+		return name
+	}
+	return "未知设备"
+}
+
+func hashRefreshSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// 生成刷新token，格式：sessionId.secret
+func newRefreshSecret() (string, error) {
+	return randomHex(32)
+}
+
+// 创建登录会话并签发token
+func createSession(sldb db.SqlliteDB, role string, userId int64, userName string, refreshTTL time.Duration, client SessionClient) (TokenPair, error) {
+	sessionId, err := randomHex(16)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	secret, err := newRefreshSecret()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	tokenId, err := randomHex(8)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	now := time.Now().Format(sessionTimeFormat)
+	if _, err := sldb.Exec(`INSERT INTO sessions (sessionId, userId, refreshHash, refreshTTL, createdAt, refreshedAt, expiresAt, deviceName, ip, userAgent, lastSeenAt, tokenId, role) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sessionId, userId, hashRefreshSecret(secret), int64(refreshTTL.Seconds()), now, now, time.Now().Add(refreshTTL).Format(sessionTimeFormat),
+		client.DeviceName, client.IP, client.UserAgent, now, tokenId, role); err != nil {
+		return TokenPair{}, err
+	}
+	token, err := CreateToken(role, userId, userName, sessionId, tokenId)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{Token: token, RefreshToken: sessionId + "." + secret, ExpiresIn: int64(accessTokenExpiry.Seconds())}, nil
+}
+
+// 使用刷新token换取新的token，刷新token同时更换；新token沿用会话签发时的角色（调整账号角色时会注销会话）
+func refreshSession(sldb db.SqlliteDB, refreshToken string, client SessionClient) (TokenPair, error) {
+	sessionId, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionId == "" || secret == "" {
+		return TokenPair{}, errSessionRevoked
+	}
+	var userId, refreshTTL int64
+	var refreshHash, prevRefreshHash, refreshedAt, expiresAt, revokedAt, role, userRole, name string
+	err := sldb.DB.QueryRow(`SELECT s.userId, s.refreshHash, s.prevRefreshHash, s.refreshTTL, s.refreshedAt, s.expiresAt, s.revokedAt, s.role, COALESCE(u.role, ''), COALESCE(u.name, '')
+		FROM sessions s LEFT JOIN users u ON u.id = s.userId WHERE s.sessionId = ?`, sessionId).
+		Scan(&userId, &refreshHash, &prevRefreshHash, &refreshTTL, &refreshedAt, &expiresAt, &revokedAt, &role, &userRole, &name)
+	if err != nil {
+		return TokenPair{}, errSessionRevoked
+	}
+	now := time.Now()
+	if revokedAt != "" || expiresAt <= now.Format(sessionTimeFormat) {
+		return TokenPair{}, errSessionRevoked
+	}
+	if userRole == "" { // 账号已删除
+		revokeSessions(sldb, revokeReasonUnbind, `sessionId = ?`, sessionId)
+		return TokenPair{}, errSessionRevoked
+	}
+	if !roleExists(role) { // 旧版本会话未记录角色，或角色已删除，需要重新登录
+		revokeSessions(sldb, revokeReasonRoleChanged, `sessionId = ?`, sessionId)
+		return TokenPair{}, errSessionRevoked
+	}
+	presented := hashRefreshSecret(secret)
+	if subtle.ConstantTimeCompare([]byte(presented), []byte(refreshHash)) != 1 {
+		lastRefresh, _ := time.ParseInLocation(sessionTimeFormat, refreshedAt, time.Local)
+		reused := prevRefreshHash != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(prevRefreshHash)) == 1
+		if !reused || now.Sub(lastRefresh) > refreshReuseGrace {
+			log.Printf("[x]会话【%s】的刷新token校验失败，注销会话", sessionId)
+			revokeSessions(sldb, revokeReasonTokenReuse, `sessionId = ?`, sessionId)
+			return TokenPair{}, errSessionRevoked
+		}
+	}
+	newSecret, err := newRefreshSecret()
+	if err != nil {
+		return TokenPair{}, err
+	}
+	tokenId, err := randomHex(8)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	// 以当前刷新哈希为条件更新，并发刷新时只有一个成功
+	result, err := sldb.Exec(`UPDATE sessions SET refreshHash = ?, prevRefreshHash = ?, refreshedAt = ?, expiresAt = ?, ip = ?, userAgent = ?, lastSeenAt = ?, tokenId = ?
+		WHERE sessionId = ? AND refreshHash = ? AND revokedAt = ''`,
+		hashRefreshSecret(newSecret), refreshHash, now.Format(sessionTimeFormat), now.Add(time.Duration(refreshTTL)*time.Second).Format(sessionTimeFormat),
+		client.IP, client.UserAgent, now.Format(sessionTimeFormat), tokenId, sessionId, refreshHash)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	if affected, _ := result.RowsAffected(); affected != 1 {
+		return TokenPair{}, errSessionRevoked
+	}
+	token, err := CreateToken(role, userId, name, sessionId, tokenId)
+	if err != nil {
+		return TokenPair{}, err
+	}
+	return TokenPair{Token: token, RefreshToken: sessionId + "." + newSecret, ExpiresIn: int64(accessTokenExpiry.Seconds())}, nil
+}
+
+// 注销满足条件的有效会话，并断开这些会话的WebSocket连接，返回注销的数量
+func revokeSessions(sldb db.SqlliteDB, reason string, where string, args ...any) int {
+	rows, err := sldb.DB.Query(`SELECT sessionId FROM sessions WHERE revokedAt = '' AND `+where, args...)
+	if err != nil {
+		log.Println("[x]查询会话失败:", err)
+		return 0
+	}
+	var sessionIds []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			sessionIds = append(sessionIds, id)
+		}
+	}
+	rows.Close()
+	revoked := 0
+	now := time.Now().Format(sessionTimeFormat)
+	for _, id := range sessionIds {
+		result, err := sldb.Exec(`UPDATE sessions SET revokedAt = ?, revokeReason = ? WHERE sessionId = ? AND revokedAt = ''`, now, reason, id)
+		if err != nil {
+			log.Println("[x]注销会话失败:", err)
+			continue
+		}
+		if affected, _ := result.RowsAffected(); affected == 1 {
+			revoked++
+		}
+	}
+	if wsHub != nil && len(sessionIds) > 0 {
+		wsHub.closeSessions(sessionIds, reason)
+	}
+	return revoked
+}
+
+// 校验token关联的会话是否有效，修改密码用的临时token不关联会话
+func checkSession(sldb db.SqlliteDB, token *UserToken) error {
+	if token.PwdChange {
+		return nil
+	}
+	if token.SessionID == "" {
+		return errSessionRequired
+	}
+	var revokedAt string
+	err := sldb.DB.QueryRow(`SELECT revokedAt FROM sessions WHERE sessionId = ? AND userId = ?`, token.SessionID, token.UserID).Scan(&revokedAt)
+	if err == sql.ErrNoRows || (err == nil && revokedAt != "") {
+		return errSessionRevoked
+	}
+	return err
+}
+
+var sessionTouched sync.Map // sessionId => 上次更新最后活跃时间
+
+// 更新会话的最后活跃时间和IP，同一会话每分钟最多写一次
+func touchSession(sldb db.SqlliteDB, sessionId string, ip string) {
+	if sessionId == "" {
+		return
+	}
+	now := time.Now()
+	if last, ok := sessionTouched.Load(sessionId); ok && now.Sub(last.(time.Time)) < sessionTouchInterval {
+		return
+	}
+	sessionTouched.Store(sessionId, now)
+	if _, err := sldb.Exec(`UPDATE sessions SET lastSeenAt = ?, ip = ? WHERE sessionId = ?`, now.Format(sessionTimeFormat), ip, sessionId); err != nil {
+		log.Println("[x]更新会话活跃时间失败:", err)
+	}
+}
+
+// 定时清理已过期、已注销的会话记录
+func startSessionCleaner(sldb db.SqlliteDB) {
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			before := time.Now().Add(-sessionRetention).Format(sessionTimeFormat)
+			if _, err := sldb.Exec(`DELETE FROM sessions WHERE expiresAt < ? OR (revokedAt != '' AND revokedAt < ?)`, before, before); err != nil {
+				log.Println("[x]清理登录会话失败:", err)
+			}
+			sessionTouched.Range(func(key, value any) bool {
+				if time.Since(value.(time.Time)) > sessionTouchInterval {
+					sessionTouched.Delete(key)
+				}
+				return true
+			})
+			<-ticker.C
+		}
+	}()
+}
+
+// 断开指定会话的WebSocket连接
+func (h *WSHub) closeSessions(sessionIds []string, reason string) {
+	h.mutex.RLock()
+	clients := make([]*WSClient, 0)
+	for _, client := range h.clients {
+		if client.UserToken != nil && Contains(sessionIds, client.UserToken.SessionID) {
+			clients = append(clients, client)
+		}
+	}
+	h.mutex.RUnlock()
+	data, _ := json.Marshal(WSMsg{
+		Type:       sessionRevokedWSType,
+		Content:    map[string]any{"code": 401, "reason": reason, "error": errSessionRevoked.Error()},
+		ClientType: "LD_APP",
+		TimeStamp:  time.Now().UnixMilli(),
+	})
+	for _, client := range clients {
+		client.Conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		client.safeWriteMessage(websocket.TextMessage, data)
+		go func(client *WSClient) { h.unregister <- client }(client)
+	}
+}
+
+// 刷新token（无需访问token）
+func (r Router) refreshToken(c *fiber.Ctx) error {
+	postBody := struct {
+		RefreshToken string `json:"refreshToken"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.RefreshToken == "" {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "请验证参数正确性"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	pair, err := refreshSession(r.db, postBody.RefreshToken, sessionClient(c, ""))
+	if err != nil {
+		if !errors.Is(err, errSessionRevoked) {
+			log.Println("[x]刷新token失败:", err)
+		}
+		return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
+			"code": -999,
+			"data": nil,
+			"msg":  "身份凭证过期或无效。请重新登录。",
+		})
+	}
+	if c.Cookies("ldtoken") != "" { // 网页端通过cookie访问共享文件，同步更新
+		setTokenCookie(c, pair.Token)
+	}
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = pair
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 退出登录：注销当前会话
+func (r Router) logout(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	revokeSessions(r.db, revokeReasonLogout, `sessionId = ?`, token.SessionID)
+	c.ClearCookie("ldtoken")
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = nil
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 退出所有设备：注销当前账号的所有会话
+func (r Router) logoutAll(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	count := revokeSessions(r.db, revokeReasonLogoutAll, `userId = ?`, token.UserID)
+	c.ClearCookie("ldtoken")
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{"revoked": count}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 强制下线：注销指定账号的所有会话，不能强制权限更高的账号下线
+func (r Router) forceLogout(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		UserId int64 `json:"userId"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.UserId == 0 {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "请验证参数正确性"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var role string
+	if err := r.db.DB.QueryRow(`SELECT role FROM users WHERE id = ?`, postBody.UserId).Scan(&role); err != nil {
+		r.Reply.Code = http.StatusNotFound
+		r.Reply.Msg = "账号不存在"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if !coversRole(token.Role, role) {
+		r.Reply.Code = http.StatusForbidden
+		r.Reply.Msg = "没有操作权限"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	count := revokeSessions(r.db, revokeReasonForced, `userId = ?`, postBody.UserId)
+	log.Printf("用户【%s】强制下线账号【%d】，注销会话 %d 个", token.Username, postBody.UserId, count)
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{"revoked": count}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 会话信息，online表示该会话当前有WebSocket连接
+type SessionInfo struct {
+	SessionID   string `json:"sessionId"`
+	UserID      int64  `json:"userId"`
+	UserName    string `json:"userName"`
+	NickName    string `json:"nickName"`
+	Role        string `json:"role"`
+	DeviceName  string `json:"deviceName"`
+	IP          string `json:"ip"`
+	UserAgent   string `json:"userAgent"`
+	TokenID     string `json:"tokenId"`
+	FirstSeenAt string `json:"firstSeenAt"`
+	LastSeenAt  string `json:"lastSeenAt"`
+	ExpiresAt   string `json:"expiresAt"`
+	Current     bool   `json:"current"`
+	Online      bool   `json:"online"`
+	WSClientID  string `json:"wsClientId"`
+}
+
+// 在线会话：sessionId => WebSocket连接
+type sessionPresence struct {
+	clientID string
+	lastPing time.Time
+}
+
+// 当前连接的WebSocket按会话汇总，同一会话多个连接时取最近活跃的
+func (h *WSHub) sessionPresence() map[string]sessionPresence {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	presence := make(map[string]sessionPresence)
+	for _, client := range h.clients {
+		if client.UserToken == nil || client.UserToken.SessionID == "" {
+			continue
+		}
+		client.mutex.RLock()
+		p := sessionPresence{clientID: client.clientID, lastPing: client.LastPing}
+		connected := client.IsConnected
+		client.mutex.RUnlock()
+		if !connected {
+			continue
+		}
+		if existing, ok := presence[client.UserToken.SessionID]; !ok || p.lastPing.After(existing.lastPing) {
+			presence[client.UserToken.SessionID] = p
+		}
+	}
+	return presence
+}
+
+// 查询有效会话（未注销、未过期），按最后活跃时间倒序
+func querySessions(sldb db.SqlliteDB, where string, args ...any) ([]SessionInfo, error) {
+	query := `SELECT s.sessionId, s.userId, COALESCE(u.name, ''), COALESCE(u.nickName, ''), COALESCE(u.role, ''), s.deviceName, s.ip, s.userAgent, s.tokenId, s.createdAt, s.lastSeenAt, s.expiresAt
+		FROM sessions s LEFT JOIN users u ON u.id = s.userId
+		WHERE s.revokedAt = '' AND s.expiresAt > ?`
+	args = append([]any{time.Now().Format(sessionTimeFormat)}, args...)
+	if where != "" {
+		query += " AND " + where
+	}
+	rows, err := sldb.DB.Query(query+" ORDER BY s.lastSeenAt DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var presence map[string]sessionPresence
+	if wsHub != nil {
+		presence = wsHub.sessionPresence()
+	}
+	list := make([]SessionInfo, 0)
+	for rows.Next() {
+		var s SessionInfo
+		if err := rows.Scan(&s.SessionID, &s.UserID, &s.UserName, &s.NickName, &s.Role, &s.DeviceName, &s.IP, &s.UserAgent, &s.TokenID, &s.FirstSeenAt, &s.LastSeenAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		if s.LastSeenAt == "" { // 旧版本创建的会话
+			s.LastSeenAt = s.FirstSeenAt
+		}
+		if p, ok := presence[s.SessionID]; ok {
+			s.Online = true
+			s.WSClientID = p.clientID
+			if lastPing := p.lastPing.Format(sessionTimeFormat); lastPing > s.LastSeenAt {
+				s.LastSeenAt = lastPing
+			}
+		}
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// 查询登录会话：默认为当前账号的会话，有管理用户权限时可通过 scope=all 查询所有会话或 userId 查询指定账号
+func (r Router) getSessions(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	where := "s.userId = ?"
+	args := []any{token.UserID}
+	if hasPermission(token.Role, permManageUsers) {
+		if userId := c.QueryInt("userId"); userId != 0 {
+			args = []any{userId}
+		} else if c.Query("scope") == "all" {
+			where = ""
+			args = nil
+		}
+	}
+	list, err := querySessions(r.db, where, args...)
+	if err != nil {
+		log.Println("[x]查询登录会话失败:", err)
+		r.Reply.Code = http.StatusInternalServerError
+		r.Reply.Msg = "查询失败"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	for i := range list {
+		list[i].Current = list[i].SessionID == token.SessionID
+	}
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = list
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 注销指定会话：可注销自己的任意会话，有管理用户权限时可注销其他账号的会话（不能注销权限更高的账号的会话）
+func (r Router) terminateSession(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		SessionId string `json:"sessionId"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.SessionId == "" {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "请验证参数正确性"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var userId int64
+	var role string
+	err := r.db.DB.QueryRow(`SELECT s.userId, COALESCE(u.role, '') FROM sessions s LEFT JOIN users u ON u.id = s.userId WHERE s.sessionId = ? AND s.revokedAt = ''`, postBody.SessionId).Scan(&userId, &role)
+	if err != nil {
+		r.Reply.Code = http.StatusNotFound
+		r.Reply.Msg = "会话不存在或已失效"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if userId != token.UserID && (!hasPermission(token.Role, permManageUsers) || !coversRole(token.Role, role)) {
+		r.Reply.Code = http.StatusForbidden
+		r.Reply.Msg = "没有操作权限"
+		r.Reply.Data = nil
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	count := revokeSessions(r.db, revokeReasonTerminated, `sessionId = ?`, postBody.SessionId)
+	if userId != token.UserID {
+		log.Printf("用户【%s】注销账号【%d】的会话【%s】", token.Username, userId, postBody.SessionId)
+	}
+	if postBody.SessionId == token.SessionID {
+		c.ClearCookie("ldtoken")
+	}
+	r.Reply.Code = http.StatusOK
+	r.Reply.Msg = "完成"
+	r.Reply.Data = map[string]any{"revoked": count}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+func setTokenCookie(c *fiber.Ctx, token string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "ldtoken",
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(accessTokenExpiry),
+		HTTPOnly: true,
+		Secure:   false,
+		SameSite: "Lax",
+	})
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 分享链接：基于fileCode生成短链接 /s/<code>，支持过期时间、下载次数上限、访问密码和撤销
+const shareCodeLength = 8
+
+type ShareLink struct {
+	LinkID        int64  `json:"linkId"`
+	Code          string `json:"code"`
+	FileCode      string `json:"fileCode"`
+	UserID        int64  `json:"userId"`
+	HasPassword   bool   `json:"hasPassword"`
+	ExpiresAt     string `json:"expiresAt"`    // 空字符串表示永不过期
+	MaxDownloads  int    `json:"maxDownloads"` // 0表示不限次数
+	DownloadCount int    `json:"downloadCount"`
+	RevokedAt     string `json:"revokedAt"`
+	CreatedAt     string `json:"createdAt"`
+	URL           string `json:"url"`
+	password      string
+}
+
+const shareLinkColumns = "linkId, code, fileCode, userId, password, expiresAt, maxDownloads, downloadCount, revokedAt, createdAt"
+
+func scanShareLink(scanner interface{ Scan(dest ...any) error }) (ShareLink, error) {
+	var l ShareLink
+	var createdAt sql.NullString
+	err := scanner.Scan(&l.LinkID, &l.Code, &l.FileCode, &l.UserID, &l.password, &l.ExpiresAt, &l.MaxDownloads, &l.DownloadCount, &l.RevokedAt, &createdAt)
+	l.CreatedAt = createdAt.String
+	l.HasPassword = l.password != ""
+	l.URL = "/s/" + l.Code
+	return l, err
+}
+
+// 检查链接是否可用，不可用时返回原因
+func (l ShareLink) unavailableReason() string {
+	if l.RevokedAt != "" {
+		return "分享链接已撤销"
+	}
+	if l.ExpiresAt != "" && l.ExpiresAt <= time.Now().Format("2006-01-02 15:04:05") {
+		return "分享链接已过期"
+	}
+	if l.MaxDownloads > 0 && l.DownloadCount >= l.MaxDownloads {
+		return "分享链接下载次数已用完"
+	}
+	return ""
+}
+
+// 访问密码与账号密码一样使用bcrypt哈希保存
+func hashSharePassword(password string) (string, error) {
+	return db.HashPassword(password)
+}
+
+// 校验访问密码，未设置密码时直接通过
+func checkSharePassword(stored string, password string) bool {
+	return stored == "" || db.CheckPassword(stored, password)
+}
+
+// 生成分享短码
+func randomShareCode() (string, error) {
+	const charset = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789" // 去掉易混淆字符
+	code := make([]byte, shareCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = charset[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// 创建分享链接
+func (r Router) createShareLink(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		FileCode     string `json:"fileCode"`
+		ExpireHours  int    `json:"expireHours"`  // 有效时长（小时），0表示永不过期
+		MaxDownloads int    `json:"maxDownloads"` // 最大下载次数，0表示不限
+		Password     string `json:"password"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.FileCode == "" || postBody.ExpireHours < 0 || postBody.MaxDownloads < 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if _, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? AND deletedAt IS NULL", postBody.FileCode)); err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  "文件不存在",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if len(postBody.Password) > db.PasswordMaxLength {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  fmt.Sprintf("访问密码不能超过%d字节", db.PasswordMaxLength),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	password := ""
+	if postBody.Password != "" {
+		var err error
+		if password, err = hashSharePassword(postBody.Password); err != nil {
+			return err
+		}
+	}
+	expiresAt := ""
+	if postBody.ExpireHours > 0 {
+		expiresAt = time.Now().Add(time.Duration(postBody.ExpireHours) * time.Hour).Format("2006-01-02 15:04:05")
+	}
+	var code string
+	var insertErr error
+	for range 5 { // 短码冲突时重试
+		if code, insertErr = randomShareCode(); insertErr != nil {
+			break
+		}
+		_, insertErr = r.db.Exec(`INSERT INTO share_links (code, fileCode, userId, password, expiresAt, maxDownloads, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			code, postBody.FileCode, token.UserID, password, expiresAt, postBody.MaxDownloads, time.Now().Format("2006-01-02 15:04:05"))
+		if insertErr == nil || !strings.Contains(insertErr.Error(), "UNIQUE") {
+			break
+		}
+	}
+	if insertErr != nil {
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "创建分享链接失败",
+			Data: insertErr.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	link, _ := scanShareLink(r.db.DB.QueryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE code = ?", code))
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: link,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 获取分享链接列表，有管理共享文件权限的账号可查看所有人的链接
+func (r Router) getShareLinks(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	query := "SELECT " + shareLinkColumns + " FROM share_links WHERE userId = ? ORDER BY linkId DESC"
+	args := []any{token.UserID}
+	if hasPermission(token.Role, permManageFiles) {
+		query = "SELECT " + shareLinkColumns + " FROM share_links ORDER BY linkId DESC"
+		args = nil
+	}
+	rows, err := r.db.DB.Query(query, args...)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "query failed",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	defer rows.Close()
+	links := []ShareLink{}
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			log.Printf("扫描行失败: %v", err)
+			continue
+		}
+		links = append(links, l)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: links,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 获取本人（有管理共享文件权限时可获取所有）可管理的分享链接
+func (r Router) getOwnShareLink(token *UserToken, code string) (ShareLink, error) {
+	l, err := scanShareLink(r.db.DB.QueryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE code = ?", code))
+	if err != nil || (l.UserID != token.UserID && !hasPermission(token.Role, permManageFiles)) {
+		return l, fmt.Errorf("分享链接不存在")
+	}
+	return l, nil
+}
+
+// 撤销分享链接
+func (r Router) revokeShareLink(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		Code string `json:"code"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.Code == "" {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	l, err := r.getOwnShareLink(token, postBody.Code)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if _, err := r.db.Exec(`UPDATE share_links SET revokedAt = ? WHERE linkId = ? AND revokedAt = ''`, time.Now().Format("2006-01-02 15:04:05"), l.LinkID); err != nil {
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "撤销分享链接失败",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: nil,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 获取分享链接的下载记录
+func (r Router) getShareLinkLogs(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	l, err := r.getOwnShareLink(token, c.Query("code"))
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	logs := r.db.QueryList(`SELECT dId, ip, userAgent, time FROM share_downloads WHERE linkId = ? ORDER BY dId DESC`, l.LinkID)
+	if logs == nil {
+		logs = []map[string]any{}
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"link": l,
+			"logs": logs,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 校验匿名访问的分享链接，返回链接及其指向的文件
+func (r Router) openShareLink(c *fiber.Ctx) (ShareLink, FileInfo, int, string) {
+	l, err := scanShareLink(r.db.DB.QueryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE code = ?", c.Params("code")))
+	if err != nil {
+		return l, FileInfo{}, http.StatusNotFound, "分享链接不存在"
+	}
+	if reason := l.unavailableReason(); reason != "" {
+		return l, FileInfo{}, http.StatusGone, reason
+	}
+	password := c.Get("X-Share-Password", c.Query("pwd"))
+	if !checkSharePassword(l.password, password) {
+		if password == "" {
+			return l, FileInfo{}, http.StatusUnauthorized, "请输入访问密码"
+		}
+		time.Sleep(500 * time.Millisecond) // 减缓暴力尝试
+		return l, FileInfo{}, http.StatusForbidden, "访问密码错误"
+	}
+	f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? AND deletedAt IS NULL", l.FileCode))
+	if err != nil {
+		return l, f, http.StatusNotFound, "分享的文件已被删除"
+	}
+	return l, f, http.StatusOK, ""
+}
+
+// 匿名查看分享信息：GET /s/:code/info
+func (r Router) getShareInfo(c *fiber.Ctx) error {
+	l, f, status, msg := r.openShareLink(c)
+	if status == http.StatusUnauthorized { // 需要密码时只返回链接状态，不暴露文件信息
+		r.Reply = Reply{
+			Code: status,
+			Msg:  msg,
+			Data: map[string]any{
+				"hasPassword": true,
+				"expiresAt":   l.ExpiresAt,
+			},
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if status != http.StatusOK {
+		r.Reply = Reply{
+			Code: status,
+			Msg:  msg,
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"fileName":      f.Name,
+			"fileSize":      f.Size,
+			"isDir":         f.IsDir,
+			"fileModTime":   f.ModTime,
+			"fileHash":      f.FileHash,
+			"hasPassword":   l.HasPassword,
+			"expiresAt":     l.ExpiresAt,
+			"maxDownloads":  l.MaxDownloads,
+			"downloadCount": l.DownloadCount,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 匿名下载分享内容：GET /s/:code?pwd=xxx，目录以zip打包下载
+func (r Router) downloadShare(c *fiber.Ctx) error {
+	l, f, status, msg := r.openShareLink(c)
+	if status != http.StatusOK {
+		r.Reply = Reply{
+			Code: status,
+			Msg:  msg,
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	filePath := filepath.Join(r.config.SharedDir, filepath.FromSlash(f.RelPath))
+	if f.IsDir || shareDownloadCounted(c, filePath, r.sharedFileHash(f.RelPath)) {
+		res, err := r.db.Exec(`UPDATE share_links SET downloadCount = downloadCount + 1 WHERE linkId = ? AND (maxDownloads = 0 OR downloadCount < maxDownloads)`, l.LinkID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 { // 并发下载时次数已被用完
+			r.Reply = Reply{
+				Code: http.StatusGone,
+				Msg:  "分享链接下载次数已用完",
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+		if _, err := r.db.Exec(`INSERT INTO share_downloads (linkId, ip, userAgent, time) VALUES (?, ?, ?, ?)`,
+			l.LinkID, c.IP(), c.Get(fiber.HeaderUserAgent), time.Now().Format("2006-01-02 15:04:05")); err != nil {
+			log.Println("[x]记录分享下载日志失败:", err)
+		}
+	}
+	if f.IsDir {
+		return r.sendArchive(c, []FileInfo{f}, archiveFormatZip, "", 0)
+	}
+	r.trackDownload(c, uploadTargetShared, f.RelPath)
+	return sendFileContent(c, filePath, "attachment", r.sharedFileHash(f.RelPath))
+}
+
+// 按解析后的Range判断本次下载是否计数：返回完整文件、从0开始或一直读到文件末尾的请求每次都计数，
+// 只有文件中间的一段不计数；Range无效（返回416）时不计数
+func shareDownloadCounted(c *fiber.Ctx, filePath string, hashOf func(fs.FileInfo) string) bool {
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if rangeHeader == "" {
+		return true
+	}
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return true
+	}
+	// If-Range与当前文件不一致时返回完整文件，与 sendFileContent 的判断保持一致
+	if !ifRangeMatch(c.Get(fiber.HeaderIfRange), fileETag(hashOf(info), info), info.ModTime().Truncate(time.Second)) {
+		return true
+	}
+	start, end, ok, err := parseByteRange(rangeHeader, info.Size())
+	if err != nil {
+		return false
+	}
+	return !ok || start == 0 || end == info.Size()-1
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"io"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+传输限速：上传、下载各有一个带宽池，分别配置全局限速和单用户限速（settings表，KB/s，0表示不限速）
+  - 每次读写前向带宽池申请额度，未配置限速时直接放行
+  - 调度器每个周期按速率补充全局和用户的令牌，先在用户之间轮转、再在同一用户的多个传输之间轮转分配，
+    单个用户开再多的并发也只占一份，空闲的额度由其他用户均分
+  - 上传限速作用于表单、分片、tus和点对点发送的请求体
+  - 下载限速作用于共享文件、用户文件、分享链接、历史版本和打包下载
+修改设置后调用 applyBandwidthLimits 立即生效，进行中的传输也按新的限速继续
+*/
+
+const (
+	throttleTick    = 20 * time.Millisecond
+	throttleQuantum = 32 * 1024 // 单次分配的最大字节数，越小轮转越均匀
+	throttleBurst   = 0.2       // 令牌最多累积0.2秒的额度，避免空闲后突发
+	throttleIdle    = 5 * time.Second
+)
+
+type bandwidthRequest struct {
+	want    int
+	granted chan int
+}
+
+type bandwidthUser struct {
+	tokens   float64
+	queue    []*bandwidthRequest
+	lastSeen time.Time
+}
+
+type bandwidthPool struct {
+	mutex    sync.Mutex
+	rate     float64 // 全局限速，字节/秒，0不限速
+	userRate float64 // 单用户限速
+	tokens   float64
+	users    map[string]*bandwidthUser
+	ring     []string // 有等待请求的用户，按轮转顺序
+	last     time.Time
+}
+
+var (
+	uploadBandwidth   = newBandwidthPool()
+	downloadBandwidth = newBandwidthPool()
+)
+
+func newBandwidthPool() *bandwidthPool {
+	p := &bandwidthPool{users: map[string]*bandwidthUser{}, last: time.Now()}
+	go p.run()
+	return p
+}
+
+// 设置限速（KB/s）
+func (p *bandwidthPool) setLimits(rateKB int, userRateKB int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.rate = float64(max(rateKB, 0)) * 1024
+	p.userRate = float64(max(userRateKB, 0)) * 1024
+}
+
+func (p *bandwidthPool) unlimited() bool {
+	return p.rate <= 0 && p.userRate <= 0
+}
+
+// 申请最多want字节的额度，阻塞到分配为止，返回实际分配的字节数
+func (p *bandwidthPool) acquire(key string, want int) int {
+	p.mutex.Lock()
+	if p.unlimited() {
+		p.mutex.Unlock()
+		return want
+	}
+	req := &bandwidthRequest{want: min(want, throttleQuantum), granted: make(chan int, 1)}
+	u := p.users[key]
+	if u == nil {
+		u = &bandwidthUser{}
+		p.users[key] = u
+	}
+	if len(u.queue) == 0 {
+		p.ring = append(p.ring, key)
+	}
+	u.queue = append(u.queue, req)
+	u.lastSeen = time.Now()
+	p.mutex.Unlock()
+	return <-req.granted
+}
+
+// 归还未用完的额度
+func (p *bandwidthPool) refund(key string, n int) {
+	if n <= 0 {
+		return
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.rate > 0 {
+		p.tokens = math.Min(p.tokens+float64(n), p.rate*throttleBurst)
+	}
+	if u := p.users[key]; u != nil && p.userRate > 0 {
+		u.tokens = math.Min(u.tokens+float64(n), p.userRate*throttleBurst)
+	}
+}
+
+func (p *bandwidthPool) run() {
+	ticker := time.NewTicker(throttleTick)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		p.mutex.Lock()
+		p.refill(now)
+		p.dispatch()
+		p.mutex.Unlock()
+	}
+}
+
+// 按经过的时间补充令牌，清理长时间没有请求的用户
+func (p *bandwidthPool) refill(now time.Time) {
+	elapsed := now.Sub(p.last).Seconds()
+	p.last = now
+	if p.rate > 0 {
+		p.tokens = math.Min(p.tokens+p.rate*elapsed, p.rate*throttleBurst)
+	}
+	for key, u := range p.users {
+		if len(u.queue) == 0 && now.Sub(u.lastSeen) > throttleIdle {
+			delete(p.users, key)
+			continue
+		}
+		if p.userRate > 0 {
+			u.tokens = math.Min(u.tokens+p.userRate*elapsed, p.userRate*throttleBurst)
+		}
+	}
+}
+
+// 轮转分配：每一轮每个用户最多分配一次，直到全局额度用完或所有用户都已用完自己的额度
+func (p *bandwidthPool) dispatch() {
+	for {
+		granted := false
+		for i := 0; i < len(p.ring); {
+			u := p.users[p.ring[i]]
+			req := u.queue[0]
+			n := float64(req.want)
+			if p.userRate > 0 {
+				n = math.Min(n, u.tokens)
+			}
+			if p.rate > 0 {
+				n = math.Min(n, p.tokens)
+			}
+			if n < 1 {
+				i++
+				continue
+			}
+			if p.userRate > 0 {
+				u.tokens -= math.Floor(n)
+			}
+			if p.rate > 0 {
+				p.tokens -= math.Floor(n)
+			}
+			req.granted <- int(n)
+			granted = true
+			u.queue = u.queue[1:]
+			if len(u.queue) == 0 {
+				p.ring = append(p.ring[:i], p.ring[i+1:]...)
+			} else {
+				i++
+			}
+		}
+		if !granted || len(p.ring) == 0 || (p.rate > 0 && p.tokens < 1) {
+			break
+		}
+	}
+	if len(p.ring) > 1 { // 下个周期从下一个用户开始，额度不足时也能轮到每个用户
+		p.ring = append(p.ring[1:], p.ring[0])
+	}
+}
+
+// 限速读取
+type throttledReader struct {
+	io.Reader
+	pool *bandwidthPool
+	key  string
+}
+
+func (t throttledReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return t.Reader.Read(b)
+	}
+	n := t.pool.acquire(t.key, len(b))
+	read, err := t.Reader.Read(b[:n])
+	t.pool.refund(t.key, n-read)
+	return read, err
+}
+
+// 限速写入
+type throttledWriter struct {
+	io.Writer
+	pool *bandwidthPool
+	key  string
+}
+
+func (t throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		n := t.pool.acquire(t.key, len(b)-written)
+		w, err := t.Writer.Write(b[written : written+n])
+		written += w
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// 限速按用户区分，匿名访问（分享链接）按IP区分
+func bandwidthKey(c *fiber.Ctx) string {
+	if token, ok := c.Locals("userToken").(*UserToken); ok {
+		return "user:" + strconv.FormatInt(token.UserID, 10)
+	}
+	return "ip:" + c.IP()
+}
+
+// 按当前配置设置限速
+func applyBandwidthLimits(config Config) {
+	uploadBandwidth.setLimits(config.UploadRateLimit, config.UserUploadRateLimit)
+	downloadBandwidth.setLimits(config.DownloadRateLimit, config.UserDownloadRateLimit)
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"LanDrop/client/fsListen"
+	"LanDrop/client/tools"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 缩略图：按内容哈希缓存到程序目录下的thumbnails目录（内容相同的文件共用缩略图，文件修改后哈希变化自动失效）
+// 共享文件在后台哈希计算完成后、聊天图片在上传完成后加入后台队列生成，接口请求时缓存不存在则即时生成
+
+var thumbnailSizes = map[string]int{ // 尺寸预设：长边像素
+	"small":  128,
+	"medium": 320,
+	"large":  1024,
+}
+
+const defaultThumbnailSize = "medium"
+
+type thumbnailJob struct {
+	srcPath string
+	hash    string
+}
+
+type cachedHash struct {
+	size    int64
+	modTime time.Time
+	hash    string
+}
+
+type thumbnailer struct {
+	dir    string
+	queue  chan thumbnailJob
+	sem    chan struct{} // 限制同时解码的图片数量
+	hashes sync.Map      // 用户文件路径 -> cachedHash，用户文件不在files索引中
+}
+
+var (
+	thumbs     *thumbnailer
+	thumbsOnce sync.Once
+)
+
+// 启动后台缩略图生成，服务重启时不会重复启动
+func startThumbnailer() {
+	thumbsOnce.Do(func() {
+		thumbs = &thumbnailer{
+			dir:   createDir(AppDir, "thumbnails"),
+			queue: make(chan thumbnailJob, 256),
+			sem:   make(chan struct{}, 2),
+		}
+		go thumbs.run()
+		fsListen.SetHashHook(thumbs.enqueue)
+	})
+}
+
+// 缩略图缓存路径：thumbnails/<哈希前两位>/<哈希>_<尺寸>.jpg
+func (t *thumbnailer) path(hash string, size string) string {
+	return filepath.Join(t.dir, hash[:2], hash+"_"+size+".jpg")
+}
+
+// 加入后台生成队列，队列已满时丢弃（请求时会即时生成）
+func (t *thumbnailer) enqueue(srcPath string, hash string) {
+	if !tools.IsThumbnailable(srcPath) || len(hash) < 2 {
+		return
+	}
+	select {
+	case t.queue <- thumbnailJob{srcPath: srcPath, hash: hash}:
+	default:
+	}
+}
+
+func (t *thumbnailer) run() {
+	for job := range t.queue {
+		for size := range thumbnailSizes {
+			if _, err := t.generate(job.srcPath, job.hash, size); err != nil {
+				log.Printf("[x]生成缩略图失败: %s %v", job.srcPath, err)
+				break
+			}
+		}
+	}
+}
+
+// 获取缩略图路径，缓存不存在时生成
+func (t *thumbnailer) generate(srcPath string, hash string, size string) (string, error) {
+	dst := t.path(hash, size)
+	if _, err := os.Stat(dst); err == nil {
+		return dst, nil
+	}
+	t.sem <- struct{}{}
+	defer func() { <-t.sem }()
+	if _, err := os.Stat(dst); err == nil { // 等待期间已由其他请求生成
+		return dst, nil
+	}
+	return dst, tools.MakeThumbnail(srcPath, dst, thumbnailSizes[size])
+}
+
+// 计算文件哈希，按路径、大小和修改时间缓存，用于用户文件和尚未完成哈希计算的共享文件
+func (t *thumbnailer) fileHash(absPath string, info fs.FileInfo) (string, error) {
+	if v, ok := t.hashes.Load(absPath); ok {
+		if h := v.(cachedHash); h.size == info.Size() && h.modTime.Equal(info.ModTime()) {
+			return h.hash, nil
+		}
+	}
+	hash, err := fsListen.HashFile(absPath)
+	if err != nil {
+		return "", err
+	}
+	t.hashes.Store(absPath, cachedHash{size: info.Size(), modTime: info.ModTime(), hash: hash})
+	return hash, nil
+}
+
+// 聊天图片上传完成后预生成缩略图
+func (t *thumbnailer) prepareUserFile(absPath string) {
+	if !tools.IsThumbnailable(absPath) {
+		return
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return
+	}
+	if hash, err := t.fileHash(absPath, info); err == nil {
+		t.enqueue(absPath, hash)
+	}
+}
+
+// 共享文件的缩略图地址，不支持的文件类型返回空字符串
+func sharedThumbnailURL(f FileInfo) string {
+	if f.IsDir || !tools.IsThumbnailable(f.Name) {
+		return ""
+	}
+	return "/api/v1/thumbnail?fileCode=" + url.QueryEscape(f.FileCode)
+}
+
+// 聊天文件的缩略图地址，不支持的文件类型返回空字符串
+func userThumbnailURL(fileURL string) string {
+	if !tools.IsThumbnailable(fileURL) {
+		return ""
+	}
+	return "/api/v1/thumbnail?url=" + url.QueryEscape(fileURL)
+}
+
+// 获取缩略图：?fileCode=共享文件 或 ?url=/user/...聊天文件，size为small、medium、large（默认medium）
+func (r Router) getThumbnail(c *fiber.Ctx) error {
+	size := c.Query("size", defaultThumbnailSize)
+	if _, ok := thumbnailSizes[size]; !ok {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "不支持的缩略图尺寸，可选 small、medium、large",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var absPath, hash string
+	var err error
+	if fileCode := c.Query("fileCode"); fileCode != "" {
+		var f FileInfo
+		if f, absPath, err = r.resolveFileCode(fileCode); err == nil {
+			if info, statErr := os.Stat(absPath); statErr == nil {
+				hash = r.sharedFileHash(f.RelPath)(info)
+			}
+		}
+	} else if fileURL := c.Query("url"); strings.HasPrefix(fileURL, "/user/") {
+		absPath, err = resolveUnder(r.userDir, strings.TrimPrefix(fileURL, "/user/"))
+	} else {
+		err = errors.New("请验证参数正确性")
+	}
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil || info.IsDir() {
+		return c.Next()
+	}
+	if !tools.IsThumbnailable(absPath) {
+		r.Reply = Reply{
+			Code: http.StatusUnsupportedMediaType,
+			Msg:  "该文件类型不支持缩略图",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if hash == "" {
+		if hash, err = thumbs.fileHash(absPath, info); err != nil {
+			return err
+		}
+	}
+	thumbPath, err := thumbs.generate(absPath, hash, size)
+	if err != nil {
+		log.Printf("[x]生成缩略图失败: %s %v", absPath, err)
+		r.Reply = Reply{
+			Code: http.StatusUnsupportedMediaType,
+			Msg:  "生成缩略图失败: " + err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	return sendFileContent(c, thumbPath, "inline", func(fs.FileInfo) string { return hash + "-" + size })
+}
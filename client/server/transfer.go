@@ -0,0 +1,335 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+/*
+传输事件：上传、下载的开始、进度、完成、失败通过WSHub实时推送，WSMsg类型为 transferEvent
+  - 推送对象：上传者、接收方以及有查看所有传输权限的用户
+  - 上传：上传者为当前用户，接收方为聊天文件的接收好友（可选）
+  - 下载：上传者为文件的上传用户（user_files），接收方为下载用户，分享链接匿名下载时为0
+  - 分片上传、tus上传按实际接收的数据推送进度；表单上传在服务端解析完表单后才能拿到文件，只推送开始和完成
+  - 下载小于1MB（图标、缩略图、视频拖动时的小段Range请求等）不推送
+*/
+
+const (
+	transferEventType = "transferEvent"
+
+	transferStarted   = "started"
+	transferProgress  = "progress"
+	transferCompleted = "completed"
+	transferFailed    = "failed"
+
+	transferUpload   = "upload"
+	transferDownload = "download"
+
+	transferProgressInterval = 500 * time.Millisecond // 进度推送间隔
+	transferIdleTimeout      = 10 * time.Minute       // 超过该时间没有数据的传输视为中断
+	minTrackedDownloadSize   = 1 << 20
+	downloadTransferKey      = "downloadTransfer" // c.Locals中待跟踪的下载
+)
+
+var (
+	errTransferIdle     = errors.New("长时间没有数据，传输已中断")
+	errTransferAborted  = errors.New("连接已断开")
+	errTransferCanceled = errors.New("上传已取消")
+)
+
+type TransferEvent struct {
+	TransferID    string  `json:"transferId"`
+	Direction     string  `json:"direction"` // upload / download
+	Event         string  `json:"event"`     // started / progress / completed / failed
+	FileName      string  `json:"fileName"`
+	FileSize      int64   `json:"fileSize"` // 本次传输的总字节数，Range下载时为区间长度
+	Bytes         int64   `json:"bytes"`    // 已传输字节数
+	Rate          float64 `json:"rate"`     // 字节/秒
+	ETA           int64   `json:"eta"`      // 预计剩余秒数，无法估算时为-1
+	UploaderID    int64   `json:"uploaderId"`
+	UploaderName  string  `json:"uploaderName"`
+	RecipientID   int64   `json:"recipientId"`
+	RecipientName string  `json:"recipientName"`
+	IP            string  `json:"ip"`
+	Error         string  `json:"error,omitempty"`
+	StartedAt     string  `json:"startedAt"`
+	Time          string  `json:"time"`
+}
+
+type transfer struct {
+	mutex       sync.Mutex
+	ev          TransferEvent
+	lastSent    time.Time
+	lastActive  time.Time
+	sampleAt    time.Time // 速率采样点
+	sampleBytes int64
+	startAt     time.Time
+	startBytes  int64 // 续传时开始跟踪前已传输的字节数
+}
+
+var transfers sync.Map // transferId => *transfer，进行中的传输
+
+func init() {
+	go sweepIdleTransfers()
+}
+
+// 开始跟踪一个传输并推送started；同一transferId已在跟踪时（分片上传的后续分片）直接返回
+func startTransfer(ev TransferEvent) *transfer {
+	now := time.Now()
+	t := &transfer{ev: ev, lastActive: now, sampleAt: now, sampleBytes: ev.Bytes, startAt: now, startBytes: ev.Bytes}
+	t.ev.Event = transferStarted
+	t.ev.ETA = -1
+	t.ev.StartedAt = now.Format("2006-01-02 15:04:05")
+	if existing, loaded := transfers.LoadOrStore(ev.TransferID, t); loaded {
+		return existing.(*transfer)
+	}
+	t.mutex.Lock()
+	t.publish(now)
+	t.mutex.Unlock()
+	return t
+}
+
+// 查询进行中的传输
+func getTransfer(transferId string) *transfer {
+	if t, ok := transfers.Load(transferId); ok {
+		return t.(*transfer)
+	}
+	return nil
+}
+
+// 结束传输，err为nil时推送completed，否则推送failed；重复结束时不做处理
+func endTransfer(transferId string, err error) {
+	if t := getTransfer(transferId); t != nil {
+		t.end(err)
+	}
+}
+
+// 增加已传输字节数，按间隔推送进度
+func (t *transfer) add(n int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.setBytes(t.ev.Bytes + n)
+}
+
+// 设置已传输字节数（分片上传以会话的receivedSize为准）
+func (t *transfer) set(bytes int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.setBytes(bytes)
+}
+
+func (t *transfer) setBytes(bytes int64) {
+	now := time.Now()
+	t.ev.Bytes = bytes
+	t.lastActive = now
+	if now.Sub(t.lastSent) < transferProgressInterval {
+		return
+	}
+	t.updateRate(now)
+	t.ev.Event = transferProgress
+	t.publish(now)
+}
+
+func (t *transfer) end(err error) {
+	if _, loaded := transfers.LoadAndDelete(t.ev.TransferID); !loaded {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	now := time.Now()
+	t.updateRate(now)
+	if err != nil {
+		t.ev.Event = transferFailed
+		t.ev.Error = err.Error()
+	} else { // 完成时给出整个传输过程的平均速率
+		t.ev.Event = transferCompleted
+		t.ev.Bytes = t.ev.FileSize
+		t.ev.ETA = 0
+		if elapsed := now.Sub(t.startAt).Seconds(); elapsed > 0 {
+			t.ev.Rate = float64(t.ev.Bytes-t.startBytes) / elapsed
+		}
+	}
+	t.publish(now)
+}
+
+// 平滑计算速率和剩余时间
+func (t *transfer) updateRate(now time.Time) {
+	elapsed := now.Sub(t.sampleAt).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(t.ev.Bytes-t.sampleBytes) / elapsed
+	if t.ev.Rate == 0 {
+		t.ev.Rate = current
+	} else {
+		t.ev.Rate = 0.7*t.ev.Rate + 0.3*current
+	}
+	t.sampleAt, t.sampleBytes = now, t.ev.Bytes
+	t.ev.ETA = -1
+	if t.ev.Rate > 0 {
+		t.ev.ETA = int64(float64(t.ev.FileSize-t.ev.Bytes) / t.ev.Rate)
+	}
+}
+
+func (t *transfer) publish(now time.Time) {
+	t.lastSent = now
+	t.ev.Time = now.Format("2006-01-02 15:04:05")
+	if wsHub == nil {
+		return
+	}
+	data, err := json.Marshal(WSMsg{
+		Type:       transferEventType,
+		Content:    t.ev,
+		ClientType: "LD_APP",
+		TimeStamp:  now.UnixMilli(),
+	})
+	if err != nil {
+		log.Printf("序列化传输事件失败: %v", err)
+		return
+	}
+	wsHub.sendToUsers(data, []int64{t.ev.UploaderID, t.ev.RecipientID}, permViewTransfers)
+}
+
+// 定时将长时间没有数据的传输标记为失败（客户端暂停或断开后不再继续）
+func sweepIdleTransfers() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		transfers.Range(func(_, value any) bool {
+			t := value.(*transfer)
+			t.mutex.Lock()
+			idle := time.Since(t.lastActive) > transferIdleTimeout
+			t.mutex.Unlock()
+			if idle {
+				t.end(errTransferIdle)
+			}
+			return true
+		})
+	}
+}
+
+// 当前用户可见的进行中传输，有查看所有传输权限时可见全部
+func listTransfers(userId int64, viewAll bool) []TransferEvent {
+	list := []TransferEvent{}
+	transfers.Range(func(_, value any) bool {
+		t := value.(*transfer)
+		t.mutex.Lock()
+		ev := t.ev
+		t.mutex.Unlock()
+		if viewAll || ev.UploaderID == userId || ev.RecipientID == userId {
+			list = append(list, ev)
+		}
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].StartedAt < list[j].StartedAt })
+	return list
+}
+
+// 统计读取字节数的Reader
+type progressReader struct {
+	io.Reader
+	t *transfer
+}
+
+func (p progressReader) Read(b []byte) (int, error) {
+	n, err := p.Reader.Read(b)
+	if n > 0 {
+		p.t.add(int64(n))
+	}
+	return n, err
+}
+
+// 下载响应体：读完后结束传输，未读完就被关闭（客户端断开）时标记为失败
+type downloadBody struct {
+	progressReader
+	closer io.Closer
+	size   int64
+}
+
+func (d downloadBody) Close() error {
+	d.t.mutex.Lock()
+	sent := d.t.ev.Bytes
+	d.t.mutex.Unlock()
+	if sent >= d.size {
+		d.t.end(nil)
+	} else {
+		d.t.end(errTransferAborted)
+	}
+	return d.closer.Close()
+}
+
+// 上传事件的基础信息
+func uploadTransfer(token *UserToken, transferId string, fileName string, fileSize int64) TransferEvent {
+	return TransferEvent{
+		TransferID:   transferId,
+		Direction:    transferUpload,
+		FileName:     fileName,
+		FileSize:     fileSize,
+		UploaderID:   token.UserID,
+		UploaderName: token.Username,
+	}
+}
+
+// 开始跟踪一个表单上传的文件，表单中的toId为聊天文件的接收方
+func (r Router) trackFormUpload(c *fiber.Ctx, form *multipart.Form, fileName string, fileSize int64) *transfer {
+	token := c.Locals("userToken").(*UserToken)
+	id, _ := randomHex(8)
+	ev := uploadTransfer(token, "up_"+id, fileName, fileSize)
+	ev.IP = c.IP()
+	if toId, err := strconv.ParseInt(formValue(form, "toId"), 10, 64); err == nil && toId > 0 {
+		ev.RecipientID = toId
+		r.db.DB.QueryRow(`SELECT name FROM users WHERE id = ?`, toId).Scan(&ev.RecipientName)
+	}
+	return startTransfer(ev)
+}
+
+// 按会话的已接收大小开始或继续跟踪分片、tus上传
+func (r Router) trackUploadSession(c *fiber.Ctx, s *UploadSession) *transfer {
+	token := c.Locals("userToken").(*UserToken)
+	ev := uploadTransfer(token, s.UploadID, s.FileName, s.FileSize)
+	ev.Bytes = s.ReceivedSize
+	ev.IP = c.IP()
+	return startTransfer(ev)
+}
+
+// 标记本次请求的下载需要推送传输事件，由sendFileContent在确定发送区间后开始跟踪。
+// target、fileKey对应user_files，用于查找文件的上传者
+func (r Router) trackDownload(c *fiber.Ctx, target string, fileKey string) {
+	ev := TransferEvent{Direction: transferDownload, IP: c.IP()}
+	if token, ok := c.Locals("userToken").(*UserToken); ok {
+		ev.RecipientID, ev.RecipientName = token.UserID, token.Username
+	}
+	query := `SELECT uf.userId, COALESCE(u.name, '') FROM user_files uf LEFT JOIN users u ON u.id = uf.userId WHERE uf.target = ? AND uf.fileKey = ?`
+	if target == uploadTargetShared { // 共享文件的fileKey为fileCode，按相对路径查询
+		query = `SELECT uf.userId, COALESCE(u.name, '') FROM user_files uf LEFT JOIN users u ON u.id = uf.userId
+			WHERE uf.target = ? AND uf.fileKey = (SELECT fileCode FROM files WHERE relPath = ? AND deletedAt IS NULL)`
+	}
+	r.db.DB.QueryRow(query, target, fileKey).Scan(&ev.UploaderID, &ev.UploaderName)
+	c.Locals(downloadTransferKey, &ev)
+}
+
+// 包装下载文件的响应体，未标记跟踪或文件较小时原样返回
+func trackedDownloadBody(c *fiber.Ctx, body io.Reader, file io.Closer, fileName string, length int64) io.ReadCloser {
+	ev, ok := c.Locals(downloadTransferKey).(*TransferEvent)
+	if !ok || length < minTrackedDownloadSize || c.Method() == fiber.MethodHead {
+		return readCloser{Reader: body, Closer: file}
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return readCloser{Reader: body, Closer: file}
+	}
+	ev.TransferID = "dl_" + id
+	ev.FileName = fileName
+	ev.FileSize = length
+	t := startTransfer(*ev)
+	return downloadBody{progressReader: progressReader{Reader: body, t: t}, closer: file, size: length}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"LanDrop/client/fsListen"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 回收站：通过接口删除的共享文件移动到程序目录下的trash目录（不在共享目录内，无法被浏览和下载），
+// 保留删除人、删除时间等信息，可还原到原位置（沿用原fileCode），超过保留天数后台自动清理
+const trashPurgeInterval = time.Hour
+
+type TrashEntry struct {
+	TrashID       int64  `json:"trashId"`
+	FileCode      string `json:"fileCode"`
+	FileName      string `json:"fileName"`
+	RelPath       string `json:"relPath"` // 原位置
+	IsDir         bool   `json:"isDir"`
+	FileSize      int64  `json:"fileSize"`
+	DeletedBy     int64  `json:"deletedBy"`
+	DeletedByName string `json:"deletedByName"`
+	DeletedAt     string `json:"deletedAt"`
+	ExpiresAt     string `json:"expiresAt"` // 自动清理时间，空字符串表示不自动清理
+	trashPath     string
+	trashKey      string // 与files表中的墓碑记录关联
+}
+
+const trashColumns = "trashId, fileCode, fileName, relPath, isDir, fileSize, deletedBy, deletedByName, deletedAt, trashPath, trashKey"
+
+func scanTrashEntry(scanner interface{ Scan(dest ...any) error }) (TrashEntry, error) {
+	var t TrashEntry
+	err := scanner.Scan(&t.TrashID, &t.FileCode, &t.FileName, &t.RelPath, &t.IsDir, &t.FileSize, &t.DeletedBy, &t.DeletedByName, &t.DeletedAt, &t.trashPath, &t.trashKey)
+	return t, err
+}
+
+// 移动文件或目录，跨磁盘无法rename时退化为复制后删除
+func moveTree(src string, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := copyTree(src, dst); err != nil {
+		os.RemoveAll(dst)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+// 统计文件或目录大小
+func treeSize(absPath string) int64 {
+	var size int64
+	filepath.WalkDir(absPath, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// 读取回收站保留天数
+func trashRetentionDays(sldb db.SqlliteDB) int {
+	days := 30
+	sldb.DB.QueryRow(`SELECT trashRetentionDays FROM settings WHERE name = 'config'`).Scan(&days)
+	return days
+}
+
+// 将共享文件移入回收站
+func (r Router) moveToTrash(token *UserToken, f FileInfo, absPath string) error {
+	dir, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+	trashPath := filepath.Join(dir, f.Name)
+	dst := filepath.Join(r.trashDir, trashPath)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	size := treeSize(absPath)
+	if err := moveTree(absPath, dst); err != nil {
+		os.RemoveAll(filepath.Dir(dst))
+		return err
+	}
+	// 回收站中的随机目录名同时作为条目标识，关联files表中被标记删除的记录
+	if err := fsListen.RemoveIndexedPath(r.db.DB, r.config.SharedDir, absPath, dir); err != nil {
+		log.Println("[x]更新删除索引失败:", err)
+	}
+	_, err = r.db.Exec(`INSERT INTO trash (fileId, fileCode, fileName, relPath, isDir, fileSize, trashPath, trashKey, deletedBy, deletedByName, deletedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		f.ID, f.FileCode, f.Name, f.RelPath, f.IsDir, size, filepath.ToSlash(trashPath), dir, token.UserID, token.Username, time.Now().Format("2006-01-02 15:04:05"))
+	return err
+}
+
+// 彻底删除回收站条目
+func removeTrashEntry(sldb db.SqlliteDB, trashDir string, versionsDir string, t TrashEntry) error {
+	purgeTrashVersions(sldb, versionsDir, t)
+	if err := os.RemoveAll(filepath.Join(trashDir, filepath.Dir(filepath.FromSlash(t.trashPath)))); err != nil {
+		return err
+	}
+	_, err := sldb.Exec(`DELETE FROM trash WHERE trashId = ?`, t.TrashID)
+	return err
+}
+
+// 清理超过保留天数的回收站条目
+func purgeExpiredTrash(sldb db.SqlliteDB, trashDir string, versionsDir string) {
+	days := trashRetentionDays(sldb)
+	if days <= 0 {
+		return
+	}
+	expiredDate := time.Now().AddDate(0, 0, -days).Format("2006-01-02 15:04:05")
+	rows, err := sldb.DB.Query("SELECT "+trashColumns+" FROM trash WHERE deletedAt < ?", expiredDate)
+	if err != nil {
+		log.Println("[x]查询过期回收站文件失败:", err)
+		return
+	}
+	var expired []TrashEntry
+	for rows.Next() {
+		if t, err := scanTrashEntry(rows); err == nil {
+			expired = append(expired, t)
+		}
+	}
+	rows.Close()
+	for _, t := range expired {
+		if err := removeTrashEntry(sldb, trashDir, versionsDir, t); err != nil {
+			log.Println("[x]清理回收站文件失败:", err)
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("已清理过期回收站文件 %d 个", len(expired))
+	}
+}
+
+var trashPurgerOnce sync.Once
+
+// 后台定时清理回收站，服务重启时不会重复启动
+func startTrashPurger(sldb db.SqlliteDB, trashDir string, versionsDir string) {
+	trashPurgerOnce.Do(func() {
+		go func() {
+			for {
+				purgeExpiredTrash(sldb, trashDir, versionsDir)
+				time.Sleep(trashPurgeInterval)
+			}
+		}()
+	})
+}
+
+// 获取回收站列表，有管理共享文件权限的账号可查看所有人删除的文件
+func (r Router) getTrashList(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	query := "SELECT " + trashColumns + " FROM trash WHERE deletedBy = ? ORDER BY trashId DESC"
+	args := []any{token.UserID}
+	if hasPermission(token.Role, permManageFiles) {
+		query = "SELECT " + trashColumns + " FROM trash ORDER BY trashId DESC"
+		args = nil
+	}
+	rows, err := r.db.DB.Query(query, args...)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "query failed",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	defer rows.Close()
+	days := trashRetentionDays(r.db)
+	entries := []TrashEntry{}
+	for rows.Next() {
+		t, err := scanTrashEntry(rows)
+		if err != nil {
+			log.Printf("扫描行失败: %v", err)
+			continue
+		}
+		if deletedAt, err := time.ParseInLocation("2006-01-02 15:04:05", t.DeletedAt, time.Local); err == nil && days > 0 {
+			t.ExpiresAt = deletedAt.AddDate(0, 0, days).Format("2006-01-02 15:04:05")
+		}
+		entries = append(entries, t)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"retentionDays": days,
+			"files":         entries,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 获取本人（有管理共享文件权限时可获取所有）可操作的回收站条目
+func (r Router) getOwnTrashEntry(token *UserToken, trashId int64) (TrashEntry, error) {
+	t, err := scanTrashEntry(r.db.DB.QueryRow("SELECT "+trashColumns+" FROM trash WHERE trashId = ?", trashId))
+	if err != nil || (t.DeletedBy != token.UserID && !hasPermission(token.Role, permManageFiles)) {
+		return t, errors.New("回收站文件不存在")
+	}
+	return t, nil
+}
+
+// 还原单个回收站条目到原位置
+func (r Router) restoreTrashEntry(t TrashEntry) (FileInfo, error) {
+	dst, err := r.resolveSharedPath(t.RelPath)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if err := checkTargetFree(dst); err != nil {
+		return FileInfo{}, err
+	}
+	// 原上级目录已被删除时重新创建，并索引新建的最上层目录
+	missing := ""
+	for p := filepath.Dir(dst); p != filepath.Clean(r.config.SharedDir); p = filepath.Dir(p) {
+		if _, err := os.Stat(p); err != nil {
+			missing = p
+		} else {
+			break
+		}
+	}
+	if missing != "" {
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return FileInfo{}, err
+		}
+		if err := fsListen.IndexPath(r.db.DB, r.config.SharedDir, missing); err != nil {
+			log.Println("[x]索引还原目录失败:", err)
+		}
+	}
+	src := filepath.Join(r.trashDir, filepath.FromSlash(t.trashPath))
+	if err := moveTree(src, dst); err != nil {
+		return FileInfo{}, err
+	}
+	if err := fsListen.RestoreIndexedPath(r.db.DB, r.config.SharedDir, dst, t.trashKey); err != nil {
+		log.Println("[x]还原索引失败:", err)
+	}
+	if err := removeTrashEntry(r.db, r.trashDir, r.versionsDir, t); err != nil {
+		log.Println("[x]删除回收站记录失败:", err)
+	}
+	return r.indexedFileInfo(dst)
+}
+
+// 还原回收站文件：{trashIds: [...]}
+func (r Router) restoreTrash(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		TrashIDs []int64 `json:"trashIds"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || len(postBody.TrashIDs) == 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	restored := []FileInfo{}
+	failed := []map[string]any{}
+	for _, trashId := range postBody.TrashIDs {
+		t, err := r.getOwnTrashEntry(token, trashId)
+		if err == nil {
+			var f FileInfo
+			if f, err = r.restoreTrashEntry(t); err == nil {
+				restored = append(restored, f)
+				continue
+			}
+		}
+		failed = append(failed, map[string]any{"trashId": trashId, "msg": err.Error()})
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"restored": restored,
+			"failed":   failed,
+		},
+	}
+	if len(restored) == 0 {
+		r.Reply.Code = http.StatusBadRequest
+		r.Reply.Msg = "还原失败"
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 清空回收站（管理共享文件权限）：{trashIds: [...]}，不传trashIds时清空全部
+func (r Router) emptyTrash(c *fiber.Ctx) error {
+	postBody := struct {
+		TrashIDs []int64 `json:"trashIds"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	var entries []TrashEntry
+	if len(postBody.TrashIDs) == 0 {
+		rows, err := r.db.DB.Query("SELECT " + trashColumns + " FROM trash")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			if t, err := scanTrashEntry(rows); err == nil {
+				entries = append(entries, t)
+			}
+		}
+		rows.Close()
+	} else {
+		for _, trashId := range postBody.TrashIDs {
+			if t, err := scanTrashEntry(r.db.DB.QueryRow("SELECT "+trashColumns+" FROM trash WHERE trashId = ?", trashId)); err == nil {
+				entries = append(entries, t)
+			}
+		}
+	}
+	removed := 0
+	for _, t := range entries {
+		if err := removeTrashEntry(r.db, r.trashDir, r.versionsDir, t); err != nil {
+			log.Println("[x]清空回收站失败:", err)
+			continue
+		}
+		removed++
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"removed": removed,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
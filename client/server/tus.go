@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// tus 1.0 断点续传协议 https://tus.io/protocols/resumable-upload
+// 支持扩展：creation、termination、checksum；上传会话与分片上传接口共用uploads表
+const (
+	tusVersion           = "1.0.0"
+	tusExtensions        = "creation,termination,checksum"
+	tusChecksumAlgorithm = "md5,sha1,sha256"
+	tusContentType       = "application/offset+octet-stream"
+	statusChecksumFailed = 460 // tus checksum扩展定义的校验失败状态码
+)
+
+// 所有tus请求的公共处理：回写协议版本，校验客户端协议版本
+func tusMiddleware(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Method() == fiber.MethodOptions {
+		return c.Next()
+	}
+	if c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return c.Status(fiber.StatusPreconditionFailed).SendString("unsupported tus version")
+	}
+	return c.Next()
+}
+
+// 解析Upload-Metadata：逗号分隔的 key base64(value)
+func parseTusMetadata(raw string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(raw, ",") {
+		kv := strings.Fields(strings.TrimSpace(pair))
+		if len(kv) == 0 {
+			continue
+		}
+		if len(kv) == 1 {
+			meta[kv[0]] = ""
+			continue
+		}
+		if v, err := base64.StdEncoding.DecodeString(kv[1]); err == nil {
+			meta[kv[0]] = string(v)
+		}
+	}
+	return meta
+}
+
+// 解析Upload-Checksum：算法 base64(摘要)
+func parseTusChecksum(raw string) (hash.Hash, []byte, error) {
+	parts := strings.Fields(raw)
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid Upload-Checksum")
+	}
+	sum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid Upload-Checksum")
+	}
+	switch strings.ToLower(parts[0]) {
+	case "md5":
+		return md5.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "sha256":
+		return sha256.New(), sum, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported checksum algorithm")
+}
+
+// tus请求的错误响应为纯文本，被上传策略拒绝时通过 Upload-Reject-Reason 返回原因代码
+func tusError(c *fiber.Ctx, err error) error {
+	var pe *policyError
+	switch {
+	case errors.As(err, &pe):
+		c.Set("Upload-Reject-Reason", pe.Reason)
+		return c.Status(pe.Status).SendString(pe.Msg)
+	case errors.Is(err, errUploadHash):
+		return c.Status(statusChecksumFailed).SendString(err.Error())
+	case errors.Is(err, errFileExists):
+		c.Set("Upload-Reject-Reason", reasonFileExists)
+		return c.Status(fiber.StatusConflict).SendString(err.Error())
+	}
+	return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+}
+
+// 获取当前用户的tus上传会话
+func (r Router) getTusSession(c *fiber.Ctx) (*UploadSession, error) {
+	token := c.Locals("userToken").(*UserToken)
+	s, err := getUploadSession(r.db, c.Params("uploadId"))
+	if err != nil || s.UserID != token.UserID {
+		return nil, fiber.ErrNotFound
+	}
+	return s, nil
+}
+
+// 完成tus上传：根据metadata中的target保存到共享目录或用户目录
+func (r Router) finishTusUpload(c *fiber.Ctx, s *UploadSession) error {
+	token := c.Locals("userToken").(*UserToken)
+	if err := r.checkUploadContent(token, s.TempPath); err != nil {
+		endTransfer(s.UploadID, err)
+		removeUploadSession(r.db, s)
+		return err
+	}
+	destPath, _ := r.uploadDestPath(s, token.Username)
+	place := userPlacer(destPath)
+	if s.Target == uploadTargetShared {
+		place = r.sharedPlacer(token, destPath, s.Conflict, &destPath)
+	}
+	if err := finishUploadSession(r.db, s, place); err != nil {
+		log.Println("[x]tus上传完成处理失败:", err)
+		// tus协议无法在完成时更换冲突策略，同名冲突与校验失败一样丢弃会话
+		if errors.Is(err, errUploadHash) || errors.Is(err, errFileExists) {
+			endTransfer(s.UploadID, err)
+			removeUploadSession(r.db, s)
+		}
+		return err
+	}
+	if s.Target == uploadTargetUser {
+		r.recordUserUpload(token.UserID, destPath)
+	} else {
+		r.recordSharedUpload(token.UserID, destPath)
+	}
+	log.Printf("tus上传完成: %s => %s", s.UploadID, destPath)
+	return nil
+}
+
+// OPTIONS 协议能力发现
+func (r Router) tusOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Checksum-Algorithm", tusChecksumAlgorithm)
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// POST 创建上传
+func (r Router) tusCreate(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	if c.Get("Upload-Defer-Length") != "" {
+		return c.Status(fiber.StatusBadRequest).SendString("Upload-Defer-Length is not supported")
+	}
+	uploadLength, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || uploadLength < 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("invalid Upload-Length")
+	}
+	meta := parseTusMetadata(c.Get("Upload-Metadata"))
+	fileName := meta["filename"]
+	if fileName == "" {
+		fileName = meta["name"]
+	}
+	if fileName == "" {
+		return c.Status(fiber.StatusBadRequest).SendString("filename is required in Upload-Metadata")
+	}
+	target := uploadTargetShared
+	if meta["target"] == uploadTargetUser {
+		target = uploadTargetUser
+	}
+	conflict, err := parseConflict(meta["conflict"])
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if err := r.checkUploadSession(token, fileName, uploadLength, target, meta["sha256"], meta["filetype"]); err != nil {
+		return tusError(c, err)
+	}
+	if target == uploadTargetShared {
+		if err := conflictPrecheck(filepath.Join(r.config.SharedDir, filepath.Base(fileName)), conflict); err != nil {
+			return tusError(c, err)
+		}
+	}
+	s, err := createUploadSession(r.db, r.tempDir, token.UserID, fileName, uploadLength, target, meta["sha256"], conflict)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	if s.FileSize == 0 { // 空文件无需PATCH，直接完成
+		if err := r.finishTusUpload(c, s); err != nil {
+			return tusError(c, err)
+		}
+	}
+	c.Set("Location", c.BaseURL()+"/tus/"+s.UploadID)
+	c.Set("Upload-Offset", strconv.FormatInt(s.ReceivedSize, 10))
+	return c.SendStatus(fiber.StatusCreated)
+}
+
+// HEAD 查询偏移量
+func (r Router) tusHead(c *fiber.Ctx) error {
+	c.Set("Cache-Control", "no-store")
+	s, err := r.getTusSession(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	c.Set("Upload-Offset", strconv.FormatInt(s.ReceivedSize, 10))
+	c.Set("Upload-Length", strconv.FormatInt(s.FileSize, 10))
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// PATCH 追加数据
+func (r Router) tusPatch(c *fiber.Ctx) error {
+	if c.Get("Content-Type") != tusContentType {
+		return c.Status(fiber.StatusUnsupportedMediaType).SendString("Content-Type must be " + tusContentType)
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).SendString("invalid Upload-Offset")
+	}
+	unlock := lockUpload(c.Params("uploadId"))
+	defer unlock()
+	s, err := r.getTusSession(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if s.Status != uploadStatusActive {
+		return c.Status(fiber.StatusForbidden).SendString("upload already finished")
+	}
+	if offset != s.ReceivedSize {
+		return c.Status(fiber.StatusConflict).SendString("Upload-Offset mismatch")
+	}
+	t := r.trackUploadSession(c, s)
+	body := io.Reader(progressReader{Reader: requestBodyReader(c), t: t})
+	var verify func() error
+	if raw := c.Get("Upload-Checksum"); raw != "" {
+		hasher, expected, err := parseTusChecksum(raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		body = io.TeeReader(body, hasher)
+		verify = func() error {
+			if !bytes.Equal(hasher.Sum(nil), expected) {
+				return fmt.Errorf("checksum mismatch")
+			}
+			return nil
+		}
+	}
+	err = writeUploadChunk(r.db, s, offset, body, verify)
+	t.set(s.ReceivedSize) // 校验失败时已丢弃的数据不计入进度
+	if err != nil {
+		switch {
+		case errors.Is(err, errUploadChecksum):
+			return c.Status(statusChecksumFailed).SendString("Checksum Mismatch")
+		case errors.Is(err, errUploadTooLarge):
+			return c.Status(fiber.StatusRequestEntityTooLarge).SendString(err.Error())
+		}
+		log.Println("[x]tus写入数据失败:", err)
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if s.ReceivedSize == s.FileSize {
+		if err := r.finishTusUpload(c, s); err != nil {
+			return tusError(c, err)
+		}
+	}
+	c.Set("Upload-Offset", strconv.FormatInt(s.ReceivedSize, 10))
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// DELETE 终止上传
+func (r Router) tusDelete(c *fiber.Ctx) error {
+	unlock := lockUpload(c.Params("uploadId"))
+	defer unlock()
+	s, err := r.getTusSession(c)
+	if err != nil {
+		return c.SendStatus(fiber.StatusNotFound)
+	}
+	if err := removeUploadSession(r.db, s); err != nil {
+		return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	return c.SendStatus(fiber.StatusNoContent)
+}
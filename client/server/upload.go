@@ -0,0 +1,609 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"LanDrop/client/fsListen"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultChunkSize   = 8 * 1024 * 1024    // 默认分片大小8MB
+	maxChunkSize       = 64 * 1024 * 1024   // 单个分片最大64MB
+	formMemoryLimit    = 16 * 1024 * 1024   // 表单上传中保留在内存的最大字节数，超出部分写入临时文件
+	uploadExpiryTime   = 7 * 24 * time.Hour // 未完成的上传保留7天
+	uploadStatusActive = "uploading"
+	uploadStatusDone   = "finished"
+	uploadTargetShared = "shared" // 上传到共享目录
+	uploadTargetUser   = "user"   // 上传到用户个人目录
+)
+
+// 分片上传会话，持久化在uploads表中，重启服务后可以继续上传
+type UploadSession struct {
+	UploadID     string `json:"uploadId"`
+	UserID       int64  `json:"userId"`
+	FileName     string `json:"fileName"`
+	FileSize     int64  `json:"fileSize"`
+	ReceivedSize int64  `json:"receivedSize"`
+	ChunkSize    int64  `json:"chunkSize"`
+	TempPath     string `json:"-"`
+	Target       string `json:"target"`
+	ExpectedHash string `json:"expectedHash"` // 客户端提供的整个文件SHA-256，完成时校验
+	Conflict     string `json:"conflict"`     // 共享目录同名文件处理策略
+	Status       string `json:"status"`
+	CreatedAt    string `json:"createdAt"`
+	ModifiedAt   string `json:"modifiedAt"`
+}
+
+var uploadLocks sync.Map // uploadId => *sync.Mutex，同一个上传会话的分片串行写入
+
+func lockUpload(uploadId string) func() {
+	l, _ := uploadLocks.LoadOrStore(uploadId, &sync.Mutex{})
+	mu := l.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// 创建上传会话，同一用户未完成的同名同大小文件直接复用旧会话实现续传
+func createUploadSession(sldb db.SqlliteDB, tempDir string, userId int64, fileName string, fileSize int64, target string, expectedHash string, conflict string) (*UploadSession, error) {
+	fileName = filepath.Base(filepath.Clean(fileName))
+	if fileName == "." || fileName == string(filepath.Separator) || fileName == ".." {
+		return nil, fmt.Errorf("文件名不合法")
+	}
+	if fileSize < 0 {
+		return nil, fmt.Errorf("文件大小不合法")
+	}
+	expectedHash = strings.ToLower(expectedHash)
+	existId, err := findResumableUpload(sldb, userId, fileName, fileSize, target, expectedHash)
+	if err != nil {
+		return nil, err
+	}
+	if existId != "" {
+		if s, err := getUploadSession(sldb, existId); err == nil {
+			if s.Conflict != conflict { // 续传时以最新请求的策略为准
+				s.Conflict = conflict
+				sldb.Exec(`UPDATE uploads SET conflict = ? WHERE uploadId = ?`, conflict, s.UploadID)
+			}
+			return s, nil
+		}
+	}
+	uploadId, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	tempPath := filepath.Join(tempDir, uploadId+".part")
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %v", err)
+	}
+	f.Close()
+	nowDate := time.Now().Format("2006-01-02 15:04:05")
+	s := &UploadSession{
+		UploadID:     uploadId,
+		UserID:       userId,
+		FileName:     fileName,
+		FileSize:     fileSize,
+		ChunkSize:    defaultChunkSize,
+		TempPath:     tempPath,
+		Target:       target,
+		ExpectedHash: expectedHash,
+		Conflict:     conflict,
+		Status:       uploadStatusActive,
+		CreatedAt:    nowDate,
+		ModifiedAt:   nowDate,
+	}
+	if _, err := sldb.Exec(`INSERT INTO uploads (uploadId, userId, fileName, fileSize, receivedSize, chunkSize, tempPath, target, expectedHash, conflict, status, createdAt, modifiedAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.UploadID, s.UserID, s.FileName, s.FileSize, 0, s.ChunkSize, s.TempPath, s.Target, s.ExpectedHash, s.Conflict, s.Status, s.CreatedAt, s.ModifiedAt); err != nil {
+		os.Remove(tempPath)
+		return nil, err
+	}
+	return s, nil
+}
+
+// 查询可续传的未完成会话，不存在时返回空字符串
+func findResumableUpload(sldb db.SqlliteDB, userId int64, fileName string, fileSize int64, target string, expectedHash string) (string, error) {
+	var existId string
+	err := sldb.DB.QueryRow(`SELECT uploadId FROM uploads WHERE userId = ? AND fileName = ? AND fileSize = ? AND target = ? AND expectedHash = ? AND status = ? ORDER BY modifiedAt DESC LIMIT 1`,
+		userId, filepath.Base(filepath.Clean(fileName)), fileSize, target, strings.ToLower(expectedHash), uploadStatusActive).Scan(&existId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return existId, err
+}
+
+// 查询上传会话，已接收大小以临时文件实际大小为准（防止异常退出时数据库与磁盘不一致）
+func getUploadSession(sldb db.SqlliteDB, uploadId string) (*UploadSession, error) {
+	s := &UploadSession{}
+	err := sldb.DB.QueryRow(`SELECT uploadId, userId, fileName, fileSize, receivedSize, chunkSize, tempPath, target, expectedHash, conflict, status, createdAt, modifiedAt FROM uploads WHERE uploadId = ?`, uploadId).
+		Scan(&s.UploadID, &s.UserID, &s.FileName, &s.FileSize, &s.ReceivedSize, &s.ChunkSize, &s.TempPath, &s.Target, &s.ExpectedHash, &s.Conflict, &s.Status, &s.CreatedAt, &s.ModifiedAt)
+	if err != nil {
+		return nil, err
+	}
+	if s.Status != uploadStatusActive {
+		return s, nil
+	}
+	info, err := os.Stat(s.TempPath)
+	if err != nil {
+		s.ReceivedSize = 0
+	} else if info.Size() < s.ReceivedSize {
+		s.ReceivedSize = info.Size()
+	}
+	return s, nil
+}
+
+var (
+	errUploadDone     = errors.New("上传已结束")
+	errUploadOffset   = errors.New("偏移量不匹配")
+	errUploadTooLarge = errors.New("分片超出文件大小")
+	errUploadChecksum = errors.New("分片校验失败")
+	errUploadHash     = errors.New("文件哈希校验失败")
+)
+
+// 按偏移量写入分片，偏移量必须等于已接收大小。
+// verify不为空时在写入后进行校验，校验失败则丢弃本次写入的数据；
+// 未校验的分片若中途断开，已写入的部分会被保留，客户端可从新的偏移量继续上传。
+func writeUploadChunk(sldb db.SqlliteDB, s *UploadSession, offset int64, body io.Reader, verify func() error) error {
+	if s.Status != uploadStatusActive {
+		return errUploadDone
+	}
+	if offset != s.ReceivedSize {
+		return fmt.Errorf("%w，期望 %d 实际 %d", errUploadOffset, s.ReceivedSize, offset)
+	}
+	f, err := os.OpenFile(s.TempPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	remaining := s.FileSize - offset
+	n, copyErr := io.Copy(f, io.LimitReader(body, remaining+1))
+	switch {
+	case n > remaining:
+		f.Truncate(offset)
+		return errUploadTooLarge
+	case copyErr == nil && verify != nil:
+		if err := verify(); err != nil {
+			f.Truncate(offset)
+			return fmt.Errorf("%w: %v", errUploadChecksum, err)
+		}
+	case copyErr != nil && verify != nil:
+		f.Truncate(offset)
+		return copyErr
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	s.ReceivedSize = offset + n
+	s.ModifiedAt = time.Now().Format("2006-01-02 15:04:05")
+	if _, err := sldb.Exec(`UPDATE uploads SET receivedSize = ?, modifiedAt = ? WHERE uploadId = ?`, s.ReceivedSize, s.ModifiedAt, s.UploadID); err != nil {
+		return err
+	}
+	return copyErr
+}
+
+// 获取请求体读取器，开启StreamRequestBody后大文件不会整体读入内存；读取按上传限速进行
+func requestBodyReader(c *fiber.Ctx) io.Reader {
+	var body io.Reader = bytes.NewReader(c.Body())
+	if stream := c.Context().RequestBodyStream(); stream != nil {
+		body = stream
+	}
+	return throttledReader{Reader: body, pool: uploadBandwidth, key: bandwidthKey(c)}
+}
+
+// 解析表单上传的请求体。不使用框架自带的表单解析，改为通过requestBodyReader读取，使表单上传同样参与上传限速；
+// 超过内存阈值的文件由标准库写入临时文件，处理完成后需调用 form.RemoveAll 清理
+func parseMultipartForm(c *fiber.Ctx) (*multipart.Form, error) {
+	mediaType, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != fiber.MIMEMultipartForm || params["boundary"] == "" {
+		return nil, http.ErrNotMultipart
+	}
+	// multipart按4KB读取，每次都要等待一个调度周期；按单次分配的最大额度缓冲，避免实际速率远低于限速
+	body := bufio.NewReaderSize(requestBodyReader(c), throttleQuantum)
+	return multipart.NewReader(body, params["boundary"]).ReadForm(formMemoryLimit)
+}
+
+// 表单字段的第一个值，不存在时返回空字符串
+func formValue(form *multipart.Form, key string) string {
+	if values := form.Value[key]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// 完成上传，校验通过后由place将临时文件移动到目标位置；会话标记为已完成并保留到过期，便于客户端重连后查询结果。
+// place失败时会话保持未完成状态，临时文件仍在原位置
+func finishUploadSession(sldb db.SqlliteDB, s *UploadSession, place func(src string) error) error {
+	if s.Status != uploadStatusActive {
+		return fmt.Errorf("上传已结束")
+	}
+	if s.ReceivedSize != s.FileSize {
+		return fmt.Errorf("文件未上传完成: %d/%d", s.ReceivedSize, s.FileSize)
+	}
+	if err := os.Truncate(s.TempPath, s.FileSize); err != nil {
+		return err
+	}
+	if s.ExpectedHash != "" {
+		sum, err := fsListen.HashFile(s.TempPath)
+		if err != nil {
+			return err
+		}
+		if sum != s.ExpectedHash {
+			return fmt.Errorf("%w: 期望 %s 实际 %s", errUploadHash, s.ExpectedHash, sum)
+		}
+	}
+	if err := place(s.TempPath); err != nil {
+		return err
+	}
+	s.Status = uploadStatusDone
+	s.ModifiedAt = time.Now().Format("2006-01-02 15:04:05")
+	if _, err := sldb.Exec(`UPDATE uploads SET status = ?, receivedSize = ?, modifiedAt = ? WHERE uploadId = ?`, s.Status, s.ReceivedSize, s.ModifiedAt, s.UploadID); err != nil {
+		log.Println("[x]更新上传记录失败:", err)
+	}
+	endTransfer(s.UploadID, nil)
+	return nil
+}
+
+// 移动到用户个人目录，文件名已带时间戳不会冲突
+func userPlacer(destPath string) func(src string) error {
+	return func(src string) error {
+		if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+			return fmt.Errorf("无法创建上传目录: %v", err)
+		}
+		if err := moveFile(src, destPath); err != nil {
+			return fmt.Errorf("移动文件失败: %v", err)
+		}
+		return nil
+	}
+}
+
+// 上传会话对应的最终保存路径，用户目录与uploadChatFiles保持一致：user/<yyyy_mm>/<userName>
+func (r Router) uploadDestPath(s *UploadSession, userName string) (string, string) {
+	if s.Target == uploadTargetUser {
+		newFilename := generateFilename(s.FileName)
+		userDir := fmt.Sprintf("%v/%v", time.Now().Format("2006_01"), userName)
+		return filepath.Join(r.userDir, userDir, newFilename), fmt.Sprintf("/user/%s/%s", userDir, newFilename)
+	}
+	return filepath.Join(r.config.SharedDir, s.FileName), "/shared/" + url.PathEscape(s.FileName)
+}
+
+// 取消上传，删除临时文件以及会话记录
+func removeUploadSession(sldb db.SqlliteDB, s *UploadSession) error {
+	if s.Status == uploadStatusActive {
+		os.Remove(s.TempPath)
+	}
+	endTransfer(s.UploadID, errTransferCanceled)
+	uploadLocks.Delete(s.UploadID)
+	_, err := sldb.Exec(`DELETE FROM uploads WHERE uploadId = ?`, s.UploadID)
+	return err
+}
+
+// 清理长时间未继续的上传会话
+func cleanExpiredUploads(sldb db.SqlliteDB) {
+	expiredDate := time.Now().Add(-uploadExpiryTime).Format("2006-01-02 15:04:05")
+	rows, err := sldb.DB.Query(`SELECT uploadId FROM uploads WHERE modifiedAt < ?`, expiredDate)
+	if err != nil {
+		log.Println("[x]查询过期上传失败:", err)
+		return
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if rows.Scan(&id) == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+	for _, id := range ids {
+		if s, err := getUploadSession(sldb, id); err == nil {
+			removeUploadSession(sldb, s)
+		}
+	}
+	if len(ids) > 0 {
+		log.Printf("已清理过期上传会话 %d 个", len(ids))
+	}
+}
+
+// 获取当前用户的上传会话，校验会话归属
+func (r Router) getOwnUploadSession(c *fiber.Ctx, uploadId string) (*UploadSession, error) {
+	token := c.Locals("userToken").(*UserToken)
+	s, err := getUploadSession(r.db, uploadId)
+	if err != nil {
+		return nil, fmt.Errorf("上传会话不存在")
+	}
+	if s.UserID != token.UserID {
+		return nil, fmt.Errorf("无权访问该上传会话")
+	}
+	return s, nil
+}
+
+// 初始化分片上传
+func (r Router) initChunkUpload(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		FileName string `json:"fileName"`
+		FileSize int64  `json:"fileSize"`
+		Sha256   string `json:"sha256"`
+		MimeType string `json:"mimeType"`
+		Conflict string `json:"conflict"` // overwrite、rename、fail
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.FileName == "" {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	conflict, err := parseConflict(postBody.Conflict)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := r.checkUploadSession(token, postBody.FileName, postBody.FileSize, uploadTargetShared, postBody.Sha256, postBody.MimeType); err != nil {
+		return sendPolicyError(c, err)
+	}
+	if err := conflictPrecheck(filepath.Join(r.config.SharedDir, filepath.Base(postBody.FileName)), conflict); err != nil {
+		return sendConflictError(c, postBody.FileName)
+	}
+	s, err := createUploadSession(r.db, r.tempDir, token.UserID, postBody.FileName, postBody.FileSize, uploadTargetShared, postBody.Sha256, conflict)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "创建上传失败",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: s,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 上传分片 PUT /uploadChunk?uploadId=xx&offset=xx，请求体为分片二进制数据
+func (r Router) uploadChunk(c *fiber.Ctx) error {
+	uploadId := c.Query("uploadId")
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if uploadId == "" || err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	contentLength := c.Request().Header.ContentLength()
+	if contentLength < 0 { // chunked编码等未声明长度的请求无法校验分片大小
+		r.Reply = Reply{
+			Code: http.StatusLengthRequired,
+			Msg:  "请求需要Content-Length",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if contentLength == 0 || contentLength > maxChunkSize {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "分片大小不合法",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	unlock := lockUpload(uploadId)
+	defer unlock()
+	s, err := r.getOwnUploadSession(c, uploadId)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	t := r.trackUploadSession(c, s)
+	err = writeUploadChunk(r.db, s, offset, progressReader{Reader: io.LimitReader(requestBodyReader(c), maxChunkSize), t: t}, nil)
+	t.set(s.ReceivedSize) // 写入失败时已丢弃的数据不计入进度
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusConflict,
+			Msg:  err.Error(),
+			Data: s,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: s,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 查询分片上传进度，客户端重连后据此继续上传
+func (r Router) getChunkUploadStatus(c *fiber.Ctx) error {
+	s, err := r.getOwnUploadSession(c, c.Query("uploadId"))
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: s,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 完成分片上传，文件落到shared目录
+func (r Router) finishChunkUpload(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		UploadID string `json:"uploadId"`
+		Sha256   string `json:"sha256"`
+		Conflict string `json:"conflict"` // 可在完成时改变初始化时指定的策略
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.UploadID == "" {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	unlock := lockUpload(postBody.UploadID)
+	defer unlock()
+	s, err := r.getOwnUploadSession(c, postBody.UploadID)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if s.Target != uploadTargetShared {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "上传目标不一致",
+			Data: s,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if postBody.Sha256 != "" {
+		s.ExpectedHash = strings.ToLower(postBody.Sha256)
+	}
+	if postBody.Conflict != "" {
+		if s.Conflict, err = parseConflict(postBody.Conflict); err != nil {
+			r.Reply = Reply{
+				Code: http.StatusBadRequest,
+				Msg:  err.Error(),
+				Data: nil,
+			}
+			return c.Status(r.Reply.Code).JSON(r.Reply)
+		}
+	}
+	if s.ReceivedSize == s.FileSize {
+		if err := r.checkUploadContent(token, s.TempPath); err != nil {
+			endTransfer(s.UploadID, err)
+			removeUploadSession(r.db, s)
+			return sendPolicyError(c, err)
+		}
+	}
+	destPath, _ := r.uploadDestPath(s, "")
+	if err := finishUploadSession(r.db, s, r.sharedPlacer(token, destPath, s.Conflict, &destPath)); err != nil {
+		if errors.Is(err, errFileExists) { // 会话保留，可改用其他策略重新完成
+			return sendConflictError(c, s.FileName)
+		}
+		if errors.Is(err, errUploadHash) { // 数据已损坏无法续传，直接丢弃
+			endTransfer(s.UploadID, err)
+			removeUploadSession(r.db, s)
+		}
+		r.Reply = Reply{
+			Code: http.StatusConflict,
+			Msg:  err.Error(),
+			Data: s,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.recordSharedUpload(token.UserID, destPath)
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"fileName": filepath.Base(destPath), // 策略为rename时可能与上传的文件名不同
+			"fileSize": s.FileSize,
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 取消分片上传
+func (r Router) cancelChunkUpload(c *fiber.Ctx) error {
+	postBody := struct {
+		UploadID string `json:"uploadId"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.UploadID == "" {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	unlock := lockUpload(postBody.UploadID)
+	defer unlock()
+	s, err := r.getOwnUploadSession(c, postBody.UploadID)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := removeUploadSession(r.db, s); err != nil {
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "取消上传失败",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: nil,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 校验表单上传文件的SHA-256
+func verifyMultipartHash(file *multipart.FileHeader, expectedHash string) error {
+	f, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != strings.ToLower(expectedHash) {
+		return fmt.Errorf("%w: 期望 %s 实际 %s", errUploadHash, strings.ToLower(expectedHash), sum)
+	}
+	return nil
+}
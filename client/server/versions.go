@@ -0,0 +1,284 @@
+package server
+
+import (
+	"LanDrop/client/db"
+	"LanDrop/client/fsListen"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 共享文件历史版本：覆盖上传（表单、分片、tus）或还原版本前，先把当前文件复制到程序目录下的versions目录。
+// 版本按fileCode归档，文件重命名、移动后依然可以查到；每个文件最多保留 maxFileVersions 个版本（0表示不保留），
+// 超出时删除最旧的版本；文件从回收站彻底删除时一并删除其历史版本。
+
+type FileVersion struct {
+	VersionID     int64  `json:"versionId"`
+	FileCode      string `json:"fileCode"`
+	FileName      string `json:"fileName"`
+	FileSize      int64  `json:"fileSize"`
+	FileHash      string `json:"fileHash"`
+	FileModTime   string `json:"fileModTime"` // 该版本被覆盖前的修改时间
+	storePath     string // versions目录下的相对路径
+	CreatedBy     int64  `json:"createdBy"` // 覆盖该版本的用户
+	CreatedByName string `json:"createdByName"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+const versionColumns = "versionId, fileCode, fileName, fileSize, fileHash, fileModTime, storePath, createdBy, COALESCE(createdByName, ''), createdAt"
+
+func scanFileVersion(scanner interface{ Scan(dest ...any) error }) (FileVersion, error) {
+	var v FileVersion
+	err := scanner.Scan(&v.VersionID, &v.FileCode, &v.FileName, &v.FileSize, &v.FileHash, &v.FileModTime, &v.storePath, &v.CreatedBy, &v.CreatedByName, &v.CreatedAt)
+	return v, err
+}
+
+// 读取每个文件保留的版本数
+func maxFileVersions(sldb db.SqlliteDB) int {
+	limit := 10
+	sldb.DB.QueryRow(`SELECT maxFileVersions FROM settings WHERE name = 'config'`).Scan(&limit)
+	return limit
+}
+
+// 覆盖共享文件前保存当前内容为历史版本，文件不存在时不做处理
+func (r Router) keepVersion(token *UserToken, absPath string) error {
+	info, err := os.Stat(absPath)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	limit := maxFileVersions(r.db)
+	if limit <= 0 {
+		return nil
+	}
+	relPath, err := filepath.Rel(r.config.SharedDir, absPath)
+	if err != nil {
+		return err
+	}
+	relPath = filepath.ToSlash(relPath)
+	var fileCode, fileHash, indexedModTime string
+	var indexedSize int64
+	query := `SELECT fileCode, fileHash, fileSize, fileModTime FROM files WHERE relPath = ? AND isDir = 0 AND deletedAt IS NULL`
+	if err := r.db.DB.QueryRow(query, relPath).Scan(&fileCode, &fileHash, &indexedSize, &indexedModTime); err != nil {
+		// 监听尚未索引到该文件时先建立索引，保证版本能关联到fileCode
+		if err := fsListen.IndexPath(r.db.DB, r.config.SharedDir, absPath); err != nil {
+			return err
+		}
+		if err := r.db.DB.QueryRow(query, relPath).Scan(&fileCode, &fileHash, &indexedSize, &indexedModTime); err != nil {
+			return err
+		}
+	}
+	modTime := info.ModTime().Format("2006-01-02 15:04:05")
+	if indexedSize != info.Size() || indexedModTime != modTime { // 索引中的哈希已过期
+		fileHash = ""
+	}
+	dir, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+	storePath := filepath.Join(fileCode, dir, info.Name())
+	dst := filepath.Join(r.versionsDir, storePath)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := copyFile(absPath, dst, info.Mode()); err != nil {
+		os.RemoveAll(filepath.Dir(dst))
+		return fmt.Errorf("保存历史版本失败: %v", err)
+	}
+	if _, err := r.db.Exec(`INSERT INTO file_versions (fileCode, fileName, fileSize, fileHash, fileModTime, storePath, createdBy, createdByName, createdAt) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		fileCode, info.Name(), info.Size(), fileHash, modTime, filepath.ToSlash(storePath),
+		token.UserID, token.Username, time.Now().Format("2006-01-02 15:04:05")); err != nil {
+		os.RemoveAll(filepath.Dir(dst))
+		return err
+	}
+	pruneFileVersions(r.db, r.versionsDir, fileCode, limit)
+	return nil
+}
+
+// 删除版本文件和记录
+func removeFileVersion(sldb db.SqlliteDB, versionsDir string, v FileVersion) error {
+	if err := os.RemoveAll(filepath.Join(versionsDir, filepath.Dir(filepath.FromSlash(v.storePath)))); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(versionsDir, v.FileCode)) // 该文件已没有其他版本时删除空目录
+	_, err := sldb.Exec(`DELETE FROM file_versions WHERE versionId = ?`, v.VersionID)
+	return err
+}
+
+// 查询版本列表，args为where条件参数
+func queryFileVersions(sldb db.SqlliteDB, where string, args ...any) ([]FileVersion, error) {
+	rows, err := sldb.DB.Query("SELECT "+versionColumns+" FROM file_versions WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	versions := []FileVersion{}
+	for rows.Next() {
+		if v, err := scanFileVersion(rows); err == nil {
+			versions = append(versions, v)
+		}
+	}
+	return versions, rows.Err()
+}
+
+// 只保留最新的limit个版本
+func pruneFileVersions(sldb db.SqlliteDB, versionsDir string, fileCode string, limit int) {
+	expired, err := queryFileVersions(sldb, "fileCode = ? ORDER BY versionId DESC LIMIT -1 OFFSET ?", fileCode, limit)
+	if err != nil {
+		log.Println("[x]查询历史版本失败:", err)
+		return
+	}
+	for _, v := range expired {
+		if err := removeFileVersion(sldb, versionsDir, v); err != nil {
+			log.Println("[x]删除历史版本失败:", err)
+		}
+	}
+}
+
+// 回收站条目被彻底删除时，删除其中所有文件的历史版本
+func purgeTrashVersions(sldb db.SqlliteDB, versionsDir string, t TrashEntry) {
+	versions, err := queryFileVersions(sldb, `fileCode IN (SELECT fileCode FROM files WHERE trashKey = ?)`, t.trashKey)
+	if err != nil {
+		log.Println("[x]查询历史版本失败:", err)
+		return
+	}
+	for _, v := range versions {
+		if err := removeFileVersion(sldb, versionsDir, v); err != nil {
+			log.Println("[x]删除历史版本失败:", err)
+		}
+	}
+}
+
+// 获取历史版本记录及其文件路径
+func (r Router) getFileVersion(versionId int64) (FileVersion, string, error) {
+	v, err := scanFileVersion(r.db.DB.QueryRow("SELECT "+versionColumns+" FROM file_versions WHERE versionId = ?", versionId))
+	if err != nil {
+		return v, "", errors.New("历史版本不存在")
+	}
+	absPath, err := resolveUnder(r.versionsDir, v.storePath)
+	return v, absPath, err
+}
+
+// 获取共享文件的历史版本列表，最新的在前
+func (r Router) getFileVersions(c *fiber.Ctx) error {
+	fileCode := c.Query("fileCode")
+	f, err := scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? ORDER BY deletedAt IS NULL DESC LIMIT 1", fileCode))
+	if fileCode == "" || err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  "文件不存在",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	versions, err := queryFileVersions(r.db, "fileCode = ? ORDER BY versionId DESC", fileCode)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "query failed",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: map[string]any{
+			"file":        f,
+			"versions":    versions,
+			"maxVersions": maxFileVersions(r.db),
+		},
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 下载历史版本 ?versionId=xx，支持Range和条件请求
+func (r Router) downloadFileVersion(c *fiber.Ctx) error {
+	v, absPath, err := r.getFileVersion(int64(c.QueryInt("versionId")))
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	return sendFileContent(c, absPath, requestDisposition(c), func(fs.FileInfo) string { return v.FileHash })
+}
+
+// 还原历史版本：当前内容先保存为新的历史版本（还原操作本身也可以撤销），再用该版本替换当前文件
+func (r Router) restoreFileVersion(c *fiber.Ctx) error {
+	token := c.Locals("userToken").(*UserToken)
+	postBody := struct {
+		VersionID int64 `json:"versionId"`
+	}{}
+	if err := c.BodyParser(&postBody); err != nil || postBody.VersionID == 0 {
+		r.Reply = Reply{
+			Code: http.StatusBadRequest,
+			Msg:  "请验证参数正确性",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	v, versionPath, err := r.getFileVersion(postBody.VersionID)
+	if err != nil {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  err.Error(),
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	f, absPath, err := r.resolveFileCode(v.FileCode)
+	if err != nil || f.IsDir {
+		r.Reply = Reply{
+			Code: http.StatusNotFound,
+			Msg:  "文件不存在或已删除，请先从回收站还原",
+			Data: nil,
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	if err := r.replaceWithVersion(token, v, versionPath, absPath); err != nil {
+		log.Println("[x]还原历史版本失败:", err)
+		r.Reply = Reply{
+			Code: http.StatusInternalServerError,
+			Msg:  "还原历史版本失败",
+			Data: err.Error(),
+		}
+		return c.Status(r.Reply.Code).JSON(r.Reply)
+	}
+	f, _ = scanFileInfo(r.db.DB.QueryRow("SELECT "+fileInfoColumns+" FROM files WHERE fileCode = ? AND deletedAt IS NULL", v.FileCode))
+	r.Reply = Reply{
+		Code: http.StatusOK,
+		Msg:  "successed",
+		Data: f,
+	}
+	return c.Status(r.Reply.Code).JSON(r.Reply)
+}
+
+// 复制版本文件到同目录的临时文件后替换当前文件，并同步索引
+func (r Router) replaceWithVersion(token *UserToken, v FileVersion, versionPath string, absPath string) error {
+	info, err := os.Stat(versionPath)
+	if err != nil {
+		return err
+	}
+	tempPath, err := sharedTempPath(absPath)
+	if err != nil {
+		return err
+	}
+	if err := copyFile(versionPath, tempPath, info.Mode()); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	if _, err := r.placeSharedFile(token, tempPath, absPath, conflictOverwrite); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return fsListen.IndexPath(r.db.DB, r.config.SharedDir, absPath)
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
@@ -82,7 +83,8 @@ var sg sqlGather.SqlGather
 func init() {
 	r = rand.New(rand.NewSource(time.Now().UnixNano()))
 	FuncMap = make(map[string]func(c *WSClient, m WebMsg))
-	InitFunc() // 初始化消息监听函数
+	InitFunc()    // 初始化消息监听函数
+	initP2PFunc() // 点对点发送文件
 
 }
 
@@ -116,7 +118,7 @@ func (h *WSHub) Run() {
 		case client := <-h.unregister: // 用户注销退出系统
 			h.unregisterClient(client)
 		case message := <-h.broadcast: // 通道广播消息
-			h.broadcastMessage(message, "admin+", "admin")
+			h.broadcastMessage(message, permViewDeviceInfo)
 		}
 	}
 }
@@ -195,14 +197,14 @@ func (h *WSHub) unregisterClient(client *WSClient) {
 	}
 }
 
-// 广播消息给所有客户端，可选的userTypeFilter参数用于过滤用户类型
-func (h *WSHub) broadcastMessage(message []byte, userTypeFilter ...string) {
+// 广播消息给所有客户端，可选的permissions参数用于过滤，只发送给拥有其中任一权限的用户
+func (h *WSHub) broadcastMessage(message []byte, permissions ...string) {
 	h.mutex.RLock()
 	clients := make([]*WSClient, 0, len(h.clients))
 	for _, client := range h.clients {
 		if client.IsActive {
-			// 如果没有过滤条件，或者用户类型匹配
-			if len(userTypeFilter) == 0 || Contains(userTypeFilter, client.UserType) {
+			// 如果没有过滤条件，或者用户拥有权限
+			if len(permissions) == 0 || clientHasPermission(client, permissions) {
 				clients = append(clients, client)
 			}
 		}
@@ -218,6 +220,34 @@ func (h *WSHub) broadcastMessage(message []byte, userTypeFilter ...string) {
 	}
 }
 
+// 发送消息给指定用户的所有连接以及拥有指定权限的用户，发送缓冲区满时丢弃该条消息
+func (h *WSHub) sendToUsers(message []byte, userIds []int64, permissions ...string) {
+	h.mutex.RLock()
+	clients := make([]*WSClient, 0, len(h.clients))
+	for _, client := range h.clients {
+		if client.IsActive && (slices.Contains(userIds, client.Id) || clientHasPermission(client, permissions)) {
+			clients = append(clients, client)
+		}
+	}
+	h.mutex.RUnlock()
+	for _, client := range clients {
+		select {
+		case client.Send <- message:
+		default:
+		}
+	}
+}
+
+// 客户端的角色是否拥有其中任一权限
+func clientHasPermission(client *WSClient, permissions []string) bool {
+	for _, permission := range permissions {
+		if hasPermission(client.UserType, permission) {
+			return true
+		}
+	}
+	return false
+}
+
 // 获取活跃连接数
 func (h *WSHub) GetActiveConnections() int {
 	h.mutex.RLock()
@@ -442,15 +472,18 @@ func (c *WSClient) handleMessage(msg WebMsg) {
 		sendCommonError(c, 400, "用户信息不一致，请确认。", msg.SID, c.clientID)
 		return
 	}
-	if c.UserToken.ExpiresAt.Before(time.Now()) { // token 过期
-		sendCommonError(c, 401, "登录已过期，请重新登录。", msg.SID, c.clientID)
+	// 访问token有效期很短且连接期间不会更新，已建立的连接不再校验其过期时间；
+	// 会话注销（退出登录、强制下线、角色变更等）时由 closeSessions 断开连接
+	fun, ok := FuncMap[msg.Type]
+	if !ok {
+		log.Printf("未知消息类型: %s", msg.Type)
 		return
 	}
-	if fun, ok := FuncMap[msg.Type]; ok { // 监听
-		fun(c, msg)
-	} else {
-		log.Printf("未知消息类型: %s", msg.Type)
+	if !wsMessageAllowed(c, msg.Type) { // 按消息权限表校验
+		sendCommonError(c, 403, "没有操作权限", msg.SID, c.clientID)
+		return
 	}
+	fun(c, msg) // 监听
 }
 
 // 发送错误消息并关闭连接
@@ -521,8 +554,17 @@ func InitFunc() {
 			content["error"] = "缺少用户信息,拒绝访问"
 			commonReply(c, m.SID, "replyClientList", -1, "缺少用户信息,拒绝访问")
 		} else {
-			dataList := sg.RunQuery("queryClients", m.User.UserId, m.User.UserId)
-			for _, item := range dataList {
+			pq, err := wsPageParams(m, 0).pageQuery(userSortFields, "id", false, "id")
+			if err != nil {
+				commonReply(c, m.SID, "replyClientList", -1, err.Error())
+				return
+			}
+			clientPage, err := sg.RunQueryPage("queryClients", pq, m.User.UserId, m.User.UserId)
+			if err != nil {
+				commonReply(c, m.SID, "replyClientList", -1, err.Error())
+				return
+			}
+			for _, item := range clientPage.List {
 				clientID := fmt.Sprintf(`%v#%v`, item["name"], item["id"])
 				cItem, ok := c.Hub.clients[clientID]
 				if ok {
@@ -533,7 +575,7 @@ func InitFunc() {
 					item["isActive"] = false
 				}
 			}
-			commonReply(c, m.SID, "replyClientList", 1, dataList)
+			commonReply(c, m.SID, "replyClientList", 1, clientPage)
 		}
 	}
 	// 添加好友
@@ -597,9 +639,18 @@ func InitFunc() {
 	FuncMap["queryChatRecords"] = func(c *WSClient, m WebMsg) {
 		uId := m.User.UserId
 		frId := m.SendData["friendId"]
-		args := []interface{}{uId, frId, frId, uId, uId, frId, frId, uId}
-		chatRecords := sg.RunQuery("queryFriendChatRecord", args...)
-		commonReply(c, m.SID, "replyChatRecords", 1, chatRecords)
+		// 默认从最新的记录开始向前翻页，每页500条
+		pq, err := wsPageParams(m, 500).pageQuery(chatSortFields, "time", true, "cId")
+		if err != nil {
+			commonReply(c, m.SID, "replyChatRecords", -1, err.Error())
+			return
+		}
+		chatPage, err := sg.RunQueryPage("queryFriendChatRecord", pq, uId, frId, frId, uId)
+		if err != nil {
+			commonReply(c, m.SID, "replyChatRecords", -1, err.Error())
+			return
+		}
+		commonReply(c, m.SID, "replyChatRecords", 1, chatPage)
 	}
 	// 修改聊天记录状态
 	FuncMap["changeChatRecordsStatus"] = func(c *WSClient, m WebMsg) {
@@ -652,6 +703,10 @@ func InitFunc() {
 			log.Println("目标客户端未在线", to, m.SID)
 		}
 	}
+	// 查询进行中的传输，有查看所有传输权限的用户可查看全部，后续变化通过transferEvent推送
+	FuncMap["queryTransfers"] = func(c *WSClient, m WebMsg) {
+		commonReply(c, m.SID, "replyTransfers", 1, listTransfers(c.Id, hasPermission(c.UserType, permViewTransfers)))
+	}
 	// 获取通知红点
 	FuncMap["getNotifyRedDotData"] = func(c *WSClient, m WebMsg) {
 		// log.Println("=================================接收到getNotifyRedDotData")
@@ -0,0 +1,209 @@
+package sqlGather
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+/*
+游标分页（keyset）：按“排序字段 + 唯一字段”记录上一页最后一行的值，下一页从该位置之后继续查询。
+相比OFFSET，翻页期间新增、删除数据不会导致重复或遗漏，深翻页也不需要扫描前面的所有行。
+原查询被包装为子查询，排序字段使用原查询的输出列名：
+
+	SELECT * FROM (原查询) WHERE (游标条件) ORDER BY 排序字段 LIMIT n+1
+*/
+
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
+type SortKey struct {
+	Column string // 原查询的输出列名
+	Desc   bool
+}
+
+type PageQuery struct {
+	Keys  []SortKey // 最后一个必须是唯一字段，保证顺序稳定
+	Limit int       // 每页条数，0表示不分页返回剩余全部
+	After []any     // 上一页最后一行的排序值，第一页为nil
+}
+
+type Page struct {
+	List       []map[string]any `json:"list"`
+	Total      int              `json:"total"`      // 不含游标条件的总条数
+	NextCursor string           `json:"nextCursor"` // 下一页游标，为空表示没有更多数据
+}
+
+type cursorData struct {
+	Sort   string `json:"s"` // 排序签名，防止切换排序后沿用旧游标
+	Values []any  `json:"v"`
+}
+
+// 排序签名，例如 "-time,-cId"
+func sortSignature(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k.Column
+		if k.Desc {
+			parts[i] = "-" + k.Column
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+/*
+ParseSort 解析排序参数：
+  - sort 为 fields 中的字段名（空时使用defaultSort），fields 映射为原查询的输出列名
+  - order 为 asc/desc（空时使用defaultDesc）
+  - idColumn 为唯一字段，追加在最后保证排序稳定
+*/
+func ParseSort(sort string, order string, fields map[string]string, defaultSort string, defaultDesc bool, idColumn string) ([]SortKey, error) {
+	if sort == "" {
+		sort = defaultSort
+	}
+	column, ok := fields[sort]
+	if !ok {
+		return nil, fmt.Errorf("不支持的排序字段: %s", sort)
+	}
+	desc := defaultDesc
+	switch strings.ToLower(order) {
+	case "":
+	case "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return nil, errors.New("order 只能为 asc 或 desc")
+	}
+	keys := []SortKey{{Column: column, Desc: desc}}
+	if column != idColumn {
+		keys = append(keys, SortKey{Column: idColumn, Desc: desc})
+	}
+	return keys, nil
+}
+
+// 创建分页查询，cursor为上一页返回的nextCursor
+func NewPageQuery(cursor string, limit int, keys []SortKey) (PageQuery, error) {
+	pq := PageQuery{Keys: keys, Limit: max(limit, 0)}
+	if cursor == "" {
+		return pq, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return pq, ErrInvalidCursor
+	}
+	var data cursorData
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&data); err != nil || data.Sort != sortSignature(keys) || len(data.Values) != len(keys) {
+		return pq, ErrInvalidCursor
+	}
+	for i, v := range data.Values {
+		if n, ok := v.(json.Number); ok { // 数字还原为整数或浮点数，与数据库中的数值比较
+			if iv, err := n.Int64(); err == nil {
+				data.Values[i] = iv
+			} else if fv, err := n.Float64(); err == nil {
+				data.Values[i] = fv
+			}
+		}
+	}
+	pq.After = data.Values
+	return pq, nil
+}
+
+// 生成包装后的分页SQL和参数，多查询一条用于判断是否还有下一页
+func (pq PageQuery) Build(query string, args ...any) (string, []any) {
+	args = append([]any{}, args...)
+	var sb strings.Builder
+	sb.WriteString("SELECT * FROM (" + query + ")")
+	if len(pq.After) == len(pq.Keys) && len(pq.Keys) > 0 {
+		// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ...
+		var conds []string
+		for i, k := range pq.Keys {
+			var parts []string
+			for j := 0; j < i; j++ {
+				parts = append(parts, pq.Keys[j].Column+" = ?")
+				args = append(args, pq.After[j])
+			}
+			op := " > ?"
+			if k.Desc {
+				op = " < ?"
+			}
+			parts = append(parts, k.Column+op)
+			args = append(args, pq.After[i])
+			conds = append(conds, "("+strings.Join(parts, " AND ")+")")
+		}
+		sb.WriteString(" WHERE " + strings.Join(conds, " OR "))
+	}
+	orders := make([]string, len(pq.Keys))
+	for i, k := range pq.Keys {
+		orders[i] = k.Column + " ASC"
+		if k.Desc {
+			orders[i] = k.Column + " DESC"
+		}
+	}
+	if len(orders) > 0 {
+		sb.WriteString(" ORDER BY " + strings.Join(orders, ", "))
+	}
+	if pq.Limit > 0 {
+		sb.WriteString(" LIMIT ?")
+		args = append(args, pq.Limit+1)
+	}
+	return sb.String(), args
+}
+
+// 统计总条数的SQL
+func CountSQL(query string) string {
+	return "SELECT COUNT(*) FROM (" + query + ")"
+}
+
+// 根据本页最后一行生成下一页游标，value返回该行指定列的值
+func (pq PageQuery) Cursor(value func(column string) any) string {
+	values := make([]any, len(pq.Keys))
+	for i, k := range pq.Keys {
+		values[i] = value(k.Column)
+	}
+	raw, err := json.Marshal(cursorData{Sort: sortSignature(pq.Keys), Values: values})
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// 查询结果多取了一条，超出Limit时截断并返回下一页游标
+func (pq PageQuery) Trim(count int) (int, bool) {
+	if pq.Limit > 0 && count > pq.Limit {
+		return pq.Limit, true
+	}
+	return count, false
+}
+
+// 按SqlMap中的查询分页
+func (sg *SqlGather) RunQueryPage(runType string, pq PageQuery, args ...any) (Page, error) {
+	query, ok := SqlMap[runType]
+	if !ok {
+		return Page{}, fmt.Errorf("不存在RunQuery类型")
+	}
+	return sg.QueryPage(query, pq, args...)
+}
+
+// 对任意查询分页
+func (sg *SqlGather) QueryPage(query string, pq PageQuery, args ...any) (Page, error) {
+	page := Page{List: []map[string]any{}}
+	if err := sg.DB.DB.QueryRow(CountSQL(query), args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+	pageSQL, pageArgs := pq.Build(query, args...)
+	if list := sg.DB.QueryList(pageSQL, pageArgs...); list != nil {
+		page.List = list
+	}
+	n, more := pq.Trim(len(page.List))
+	page.List = page.List[:n]
+	if more {
+		last := page.List[n-1]
+		page.NextCursor = pq.Cursor(func(column string) any { return last[column] })
+	}
+	return page, nil
+}
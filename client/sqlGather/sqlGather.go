@@ -35,8 +35,8 @@ var SqlMap = map[string]string{
 	WHERE 
 		f.status = 'pending' 
 		AND f.friendId = ?`,
-	// 查询客户端列表
-	"queryClients": `SELECT * FROM users
+	// 查询客户端列表（分页、排序见 RunQueryPage）
+	"queryClients": `SELECT id, avatar, name, nickName, role, ip, createdAt FROM users
 	WHERE id != ? AND id > 999
 	AND NOT EXISTS (
 		SELECT 1 FROM friendships
@@ -115,7 +115,7 @@ var SqlMap = map[string]string{
 	WHERE 
 		f.status = 'accept' 
 		AND f.userId = ?`,
-	// 查询好友的聊天记录
+	// 查询好友的聊天记录（分页、排序见 RunQueryPage）
 	"queryFriendChatRecord": `SELECT
 		c.*,
 		u_from.name AS fromName,
@@ -127,34 +127,8 @@ var SqlMap = map[string]string{
 		JOIN users u_from ON c.fromId = u_from.id
 		JOIN users u_to ON c.toId = u_to.id 
 	WHERE
-		(
-			( c.fromId = ? AND c.toId = ? ) 
-			OR ( c.fromId = ? AND c.toId = ? ) 
-		) 
-		AND (
-			c.time >= (
-			SELECT
-				COALESCE(
-					(
-					SELECT
-						time 
-					FROM
-						chat_records 
-					WHERE
-						( fromId = ? AND toId = ? ) 
-						OR ( fromId = ? AND toId = ? ) 
-					ORDER BY
-						time DESC 
-						LIMIT 1 OFFSET 499 
-					),
-					0 
-				) 
-			) 
-			OR c.time >= strftime( '%s', datetime( 'now', '-7 days' ) ) 
-		) 
-	ORDER BY
-		c.time ASC 
-		LIMIT 500`,
+		( c.fromId = ? AND c.toId = ? ) 
+		OR ( c.fromId = ? AND c.toId = ? )`,
 	// 修改聊天记录阅读状态（多条）
 	"execUpdateChatRecordsReadStatus": `UPDATE chat_records SET isRead = 'y' WHERE fromId = ? AND toId = ?`,
 	// 修改聊天记录阅读状态（1条）
@@ -0,0 +1,225 @@
+package tools
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 缩略图生成：仅使用标准库解码器（JPEG、PNG、GIF），不依赖cgo或外部程序
+
+const maxThumbnailPixels = 40 * 1000 * 1000 // 超过4000万像素的图片不生成缩略图，避免解码占用过多内存
+
+var ErrImageTooLarge = errors.New("图片分辨率过大")
+
+var thumbnailExts = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// 根据扩展名判断是否支持生成缩略图
+func IsThumbnailable(fileName string) bool {
+	return thumbnailExts[strings.ToLower(filepath.Ext(fileName))]
+}
+
+/*
+MakeThumbnail 生成JPEG缩略图：
+  - 按比例缩放到长边不超过maxSize，小图不放大
+  - 透明背景填充为白色，JPEG按EXIF方向旋转
+  - 先写入临时文件再重命名，并发生成同一缩略图时不会读到半个文件
+*/
+func MakeThumbnail(srcPath string, dstPath string, maxSize int) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	cfg, format, err := image.DecodeConfig(bufio.NewReader(src))
+	if err != nil {
+		return err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return ErrImageTooLarge
+	}
+	orientation := 1
+	if format == "jpeg" {
+		src.Seek(0, io.SeekStart)
+		orientation = jpegOrientation(src)
+	}
+	src.Seek(0, io.SeekStart)
+	img, _, err := image.Decode(bufio.NewReader(src))
+	if err != nil {
+		return err
+	}
+	thumb := orient(downscale(img, maxSize), orientation)
+
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: 82}); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dstPath)
+}
+
+// 区域平均缩放（每个目标像素取对应源区域的平均值），缩小倍数大时比最近邻清晰，并合成到白色背景
+func downscale(img image.Image, maxSize int) *image.RGBA {
+	b := img.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	dw, dh := sw, sh
+	if sw > maxSize || sh > maxSize {
+		if sw >= sh {
+			dw, dh = maxSize, max(1, sh*maxSize/sw)
+		} else {
+			dw, dh = max(1, sw*maxSize/sh), maxSize
+		}
+	}
+	srcImg := image.NewNRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(srcImg, srcImg.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, bl, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				row := srcImg.Pix[sy*srcImg.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					a := uint64(p[3])
+					white := 255 * (255 - a)
+					r += (uint64(p[0])*a + white) / 255
+					g += (uint64(p[1])*a + white) / 255
+					bl += (uint64(p[2])*a + white) / 255
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255})
+		}
+	}
+	return dst
+}
+
+// 按EXIF方向（1-8）旋转或翻转图片
+func orient(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 { // 5-8 需要交换宽高
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			dst.SetRGBA(dx, dy, img.RGBAAt(x, y))
+		}
+	}
+	return dst
+}
+
+// 读取JPEG中EXIF的方向标签（0x0112），读取失败时返回1（不旋转）
+func jpegOrientation(r io.Reader) int {
+	br := bufio.NewReader(r)
+	var marker [2]byte
+	if _, err := io.ReadFull(br, marker[:]); err != nil || marker != [2]byte{0xFF, 0xD8} {
+		return 1
+	}
+	for {
+		if _, err := io.ReadFull(br, marker[:]); err != nil || marker[0] != 0xFF {
+			return 1
+		}
+		if marker[1] == 0xDA || marker[1] == 0xD9 { // 图像数据开始，之后不会再有EXIF
+			return 1
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			return 1
+		}
+		segLen := int(binary.BigEndian.Uint16(lenBuf[:])) - 2
+		if segLen < 0 {
+			return 1
+		}
+		if marker[1] != 0xE1 {
+			if _, err := br.Discard(segLen); err != nil {
+				return 1
+			}
+			continue
+		}
+		seg := make([]byte, segLen)
+		if _, err := io.ReadFull(br, seg); err != nil {
+			return 1
+		}
+		if len(seg) < 14 || string(seg[:6]) != "Exif\x00\x00" {
+			continue
+		}
+		return exifOrientation(seg[6:])
+	}
+}
+
+// 解析TIFF结构的IFD0，查找方向标签
+func exifOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
@@ -11,6 +11,9 @@ import { useConsole, ConsoleViewer } from '@/hooks/useConsole'
 import { MonitorCog, CircleX } from 'lucide-react'
 import { toast } from "sonner"
 import { useWebSocket } from "@/hooks/useWebSocket"
+import { useTokenRefresh } from "@/hooks/useTokenRefresh"
+import { Dialog, DialogContent, DialogDescription, DialogHeader, DialogTitle } from "@/components/ui/dialog"
+import { ChangePasswordForm } from "@/components/main/app-change-password"
 // import { useLogsStore } from "@/store/deviceLogsStore"
 
 export default function App() {
@@ -19,6 +22,7 @@ export default function App() {
   const { request } = useApiRequest()
   const { sendMessage } = useWebSocket()
   const consoleHook = useConsole()
+  useTokenRefresh()
 
   // 只订阅需要响应式更新的状态
   const isClient = useStore(state => state.isClient)
@@ -44,6 +48,8 @@ export default function App() {
   })
 
   const [trigger, setTrigger] = useState(false) // 自定义比较函数，避免不必要的更新
+  // 首次登录需要修改默认密码时暂存的登录信息
+  const [pwdChangeInfo, setPwdChangeInfo] = useState<{ loginInfo: Record<string, any>, loginType: string, userData: Record<string, any> } | null>(null)
 
   // 检测是否为客户端首页路由
   const checkPagePath = useCallback(() => {
@@ -171,6 +177,33 @@ export default function App() {
     }
   }, [wsHandle, trigger, sendMessage])
 
+  // 登录完成，保存用户信息
+  const finishLogin = useCallback((userData: Record<string, any>, password: string, loginType: string) => {
+    toast.success("登录成功")
+
+    if (loginType === 'change') {
+      sidebarRef.current?.closeLoginDialog()
+    }
+
+    useStore.setState({
+      userInfo: {
+        token: userData.token,
+        userName: userData.adminName,
+        nickName: userData.nickName,
+        userId: userData.adminId,
+        role: userData.role,
+        avatar: userData.avatar,
+        userPwd: btoa(btoa(password)),
+        refreshToken: userData.refreshToken || "",
+        tokenExpiresAt: Date.now() + (userData.expiresIn || 0) * 1000,
+      }
+    })
+
+    // 连接WebSocket和获取网络信息
+    connectWS(userData.adminId, userData.adminName, userData.token)
+    getNetworkInfo()
+  }, [connectWS, getNetworkInfo])
+
   // 应用登录
   const appLogin = useCallback((loginInfo: Record<string, any>, loginType: string) => {
     request("/appLogin", "POST", {
@@ -178,34 +211,39 @@ export default function App() {
       timeStamp: Date.now().toString()
     }).then(res => {
       if (res?.code === 200) {
-        toast.success("登录成功")
-
-        if (loginType === 'change') {
-          sidebarRef.current?.closeLoginDialog()
+        if (res.data.mustChangePwd) { // 默认密码登录，修改密码后才能继续使用
+          toast.warning("请先修改初始密码")
+          setPwdChangeInfo({ loginInfo, loginType, userData: res.data })
+          return
         }
-
-        const userData = res.data
-        useStore.setState({
-          userInfo: {
-            token: userData.token,
-            userName: userData.adminName,
-            nickName: userData.nickName,
-            userId: userData.adminId,
-            role: userData.role,
-            avatar: userData.avatar,
-            userPwd: btoa(btoa(loginInfo.adminPassword))
-          }
-        })
-
-        // 连接WebSocket和获取网络信息
-        connectWS(userData.adminId, userData.adminName, userData.token)
-        getNetworkInfo()
+        finishLogin(res.data, loginInfo.adminPassword, loginType)
       }
     }).catch(error => {
       console.error("登录失败:", error)
       toast.error("登录失败")
     })
-  }, [request, connectWS, getNetworkInfo])
+  }, [request, finishLogin])
+
+  // 修改初始密码，成功后使用新token完成登录
+  const changePassword = useCallback((newPwd: string) => {
+    if (!pwdChangeInfo) return
+    const { loginInfo, loginType, userData } = pwdChangeInfo
+    request("/changePassword", "POST", {
+      oldPassword: loginInfo.adminPassword,
+      newPassword: newPwd,
+    }, {
+      headers: {
+        "Content-Type": "application/json",
+        "X-Ld-Token": userData.token,
+      }
+    }).then(res => {
+      if (res?.code === 200) {
+        adminLoginInfo.current = { adminName: loginInfo.adminName, adminPassword: newPwd }
+        setPwdChangeInfo(null)
+        finishLogin({ ...userData, ...res.data }, newPwd, loginType)
+      }
+    })
+  }, [request, pwdChangeInfo, finishLogin])
 
   // 管理员登录
   const appAdminLogin = useCallback((adminId: string, adminPwd: string) => {
@@ -233,6 +271,17 @@ export default function App() {
         </main>
       </SidebarInset>
 
+      <Dialog open={pwdChangeInfo !== null}>
+        <DialogContent className="sm:max-w-[425px]" onInteractOutside={e => e.preventDefault()} onEscapeKeyDown={e => e.preventDefault()}>
+          <DialogHeader>
+            <DialogTitle></DialogTitle>
+            <DialogDescription asChild>
+              <ChangePasswordForm submitEvent={changePassword} />
+            </DialogDescription>
+          </DialogHeader>
+        </DialogContent>
+      </Dialog>
+
       {devMode && (
         <Drawer>
           <DrawerTrigger asChild>
@@ -16,6 +16,7 @@ import { toast } from "sonner"
 import { Outlet, useNavigate } from 'react-router-dom'
 import { userAvatar } from "@/app/commonData"
 import { useWebSocket } from "@/hooks/useWebSocket"
+import { useTokenRefresh } from "@/hooks/useTokenRefresh"
 import { useLocation } from "react-router-dom"
 export default function AppWeb() {
     const { checkIsClient, setStoreData, closeWS, validExpToken, userInfo, wsHandle, redDotCount } = useStore()
@@ -23,6 +24,7 @@ export default function AppWeb() {
     const { request } = useApiRequest()
     const navigate = useNavigate()
     const { sendMessage } = useWebSocket()
+    useTokenRefresh()
     // 分享文件列表信息
     const [openAlert, setOpenAlert] = useState<boolean>(false)
     const [openUserDialog, setOpenUserDialog] = useState<boolean>(true)
@@ -133,7 +135,7 @@ export default function AppWeb() {
     const getUserList = () => { // 获取用户列表
         return new Promise((reslove) => {
             request("/getUserList", 'POST', {}).then(res => {
-                if (res?.code === 200) !!res.data && setUserList(res.data.map((item: any) => ({ ...item, isChange: false })))
+                if (res?.code === 200) !!res.data && setUserList(res.data.list.map((item: any) => ({ ...item, isChange: false })))
                 reslove(res)
             })
         })
@@ -200,6 +202,8 @@ export default function AppWeb() {
                             role: "",
                             avatar: "",
                             userPwd: "",
+                            refreshToken: "",
+                            tokenExpiresAt: 0,
                         }
                     })
                 },
@@ -208,21 +212,28 @@ export default function AppWeb() {
         }
         let userItem = userList.find((item: any) => item.id === checkId)
         let token = userInfo.token
+        let refreshToken = userInfo.refreshToken
+        let tokenExpiresAt = userInfo.tokenExpiresAt
         localStorage.setItem("rememberUserInfo", JSON.stringify(userItem)) // 设置用户信息
-        if (userItem.id !== userInfo.userId) {
-            console.log("不是当前用户，重新请求")
+        if (userItem.id !== userInfo.userId || !token) {
+            console.log("不是当前用户或登录已失效，重新请求")
             const tokenRes = await getUserToken(userItem.id, userItem.name) // 选择用户获取token
             token = tokenRes.data.token
+            refreshToken = tokenRes.data.refreshToken
+            tokenExpiresAt = Date.now() + tokenRes.data.expiresIn * 1000
         }
         // localStorage.setItem("userToken", tokenRes.data.token) // 设置用户token
         setStoreData({
             before: (store, set) => {
                 set({
+                    validExpToken: false,
                     userInfo: {
                         ...store.userInfo,
                         userId: userItem.id,
                         userName: userItem.name,
                         token,
+                        refreshToken,
+                        tokenExpiresAt,
                     }
                 })
             }
@@ -201,7 +201,7 @@ export default function ChatBox() {
     },
     // 客户端列表，用于添加好友
     "replyClientList": (content: any) => {
-      setUsers(content.data || [])
+      setUsers(content.data?.list || [])
     },
     // 添加好友回调，[@送达方会接收回调]
     "replyAddFriends": (content: any) => {
@@ -234,7 +234,7 @@ export default function ChatBox() {
         let clientID = `${chatUser?.friendName}#${chatUser?.friendId}`
         setMessages((prev: Record<string, Array<Message>>) => ({
           ...prev,
-          [clientID]: content.data
+          [clientID]: [...content.data.list].reverse() // 服务端按时间倒序分页返回
         }))
       }
     },
@@ -49,7 +49,7 @@ export interface DirItem {
     fileCode: string
 }
 export default function DirList(props: { dirData: any, sharedDir: string, className?: string, reload: () => void }) {
-    const { isClient } = useStore()
+    const { isClient, userInfo } = useStore()
     const { baseHost } = useApiRequest()
     const [showType, setShowType] = React.useState("card")
     const [activeFile, setActiveFile] = React.useState<DirItem>({
@@ -66,11 +66,13 @@ export default function DirList(props: { dirData: any, sharedDir: string, classN
     const [openDialog, setOpenDialog] = React.useState(false)
     const [fileInfo, setFileInfo] = React.useState<any>({})
     const baseServer = baseHost + '/shared/'
+    // /shared 需要登录凭证，客户端跨域访问时无法携带cookie，通过token参数传递
+    const sharedUrl = (uriName: string) => baseServer + uriName + '?token=' + encodeURIComponent(userInfo.token || '')
     const changeShowType = (type: string) => {
         setShowType(type)
     }
     const getTxtFileData = (item: DirItem) => {
-        fetch(baseServer + item.uriName).then(res => res.text()).then(res => {
+        fetch(sharedUrl(item.uriName)).then(res => res.text()).then(res => {
             setTxtFileData(res)
         })
     }
@@ -97,7 +99,7 @@ export default function DirList(props: { dirData: any, sharedDir: string, classN
     }
 
     const downloadEvent = (uri_name: string) => {
-        window.open(baseServer + uri_name)
+        window.open(sharedUrl(uri_name) + '&download=1')
     }
     return (
         <div className={props.className}>
@@ -256,10 +258,10 @@ export default function DirList(props: { dirData: any, sharedDir: string, classN
                                         {showType === 'columns' && <>
                                             <p className="pb-2">预览</p>
                                             { // 图片预览
-                                                activeFile.fileName && getFileType(activeFile.fileName) === 'picture' && <img src={baseServer + activeFile.uriName} style={{ height: "50%" }} />
+                                                activeFile.fileName && getFileType(activeFile.fileName) === 'picture' && <img src={sharedUrl(activeFile.uriName)} style={{ height: "50%" }} />
                                             }
                                             { // 视频预览
-                                                getFileType(activeFile.fileName) === 'video' && <video src={baseServer + activeFile.uriName} controls>
+                                                getFileType(activeFile.fileName) === 'video' && <video src={sharedUrl(activeFile.uriName)} controls>
                                                     您的浏览器不支持视频播放
                                                 </video>
                                             }
@@ -0,0 +1,91 @@
+import { cn } from "@/lib/utils"
+import { Button } from "@/components/ui/button"
+import {
+    Card,
+    CardContent,
+    CardDescription,
+    CardHeader,
+    CardTitle,
+} from "@/components/ui/card"
+import { Input } from "@/components/ui/input"
+import { Label } from "@/components/ui/label"
+import { FormEvent, useState } from "react"
+import { toast } from "sonner"
+type ChangePasswordFormProps = {
+    className?: string,
+    submitEvent?: (newPwd: string) => void, // 提交新密码
+}
+export function ChangePasswordForm({
+    className,
+    submitEvent
+}: ChangePasswordFormProps) {
+    const [formData, setFormData] = useState({
+        newPassword: "",
+        confirmPassword: ""
+    });
+
+    // 处理输入变化
+    const handleInputChange = (e: React.ChangeEvent<HTMLInputElement>) => {
+        const { id, value } = e.target;
+        setFormData(prev => ({
+            ...prev,
+            [id]: value
+        }));
+    };
+
+    // 处理表单提交
+    const handleSubmit = (e: FormEvent) => {
+        e.preventDefault();
+        if (formData.newPassword.length < 8) {
+            toast.error("密码长度不能少于8位")
+            return
+        }
+        if (formData.newPassword !== formData.confirmPassword) {
+            toast.error("两次输入的密码不一致")
+            return
+        }
+        submitEvent?.(formData.newPassword)
+    };
+
+    return (
+        <div className={cn("flex flex-col gap-6", className)}>
+            <Card>
+                <CardHeader>
+                    <CardTitle className="text-xl">修改初始密码</CardTitle>
+                    <CardDescription>
+                        当前账号仍在使用默认密码，局域网内的设备都可以访问本机，请先设置新密码
+                    </CardDescription>
+                </CardHeader>
+                <CardContent>
+                    <form onSubmit={handleSubmit}>
+                        <div className="flex flex-col gap-6">
+                            <div className="grid gap-3">
+                                <Label htmlFor="newPassword">新密码（至少8位）</Label>
+                                <Input
+                                    id="newPassword"
+                                    type="password"
+                                    value={formData.newPassword}
+                                    onChange={handleInputChange}
+                                    required
+                                />
+                            </div>
+                            <div className="grid gap-3">
+                                <Label htmlFor="confirmPassword">确认新密码</Label>
+                                <Input
+                                    id="confirmPassword"
+                                    type="password"
+                                    value={formData.confirmPassword}
+                                    onChange={handleInputChange}
+                                    required
+                                />
+                            </div>
+                            <div className="flex flex-col gap-3">
+                                <Button type="submit" className="w-full">修改密码</Button>
+                            </div>
+                        </div>
+                    </form>
+                </CardContent>
+            </Card>
+        </div>
+    )
+}
@@ -22,6 +22,7 @@ interface ScanResult {
 
 const NetworkScanner = () => {
     const ipv4Address = useStore(state => state.ipv4Address);
+    const token = useStore(state => state.userInfo.token);
     const [scanResult, setScanResult] = useState<ScanResult | null>(null);
     const [isLoading, setIsLoading] = useState(false);
     const [progress, setProgress] = useState(0);
@@ -71,7 +72,7 @@ const NetworkScanner = () => {
         }, 500);
 
         // 执行扫描
-        ToolsScanNetwork(ipAddress, subnetMask)
+        ToolsScanNetwork(token, ipAddress, subnetMask)
             .then((res: any) => {
                 console.log(res, "res")
                 setScanResult(res);
@@ -90,7 +91,7 @@ const NetworkScanner = () => {
                 clearInterval(progressInterval);
                 setIsLoading(false);
             });
-    }, [ipAddress, subnetMask, validateInputs]);
+    }, [token, ipAddress, subnetMask, validateInputs]);
 
     // 计算设备在多圈圆弧上的位置
     const getDevicePosition = useCallback((index: number, total: number) => {
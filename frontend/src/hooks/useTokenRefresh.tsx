@@ -0,0 +1,58 @@
+import { useEffect } from 'react';
+import axios from 'axios';
+import useStore from '@/store/appStore';
+
+/**
+ * 访问token到期前使用刷新token自动换取新token（刷新token每次都会更换）
+ * 多个页面共用登录信息，其他页面刷新后同步最新的token
+ */
+export function useTokenRefresh() {
+    const isClient = useStore(state => state.isClient);
+    const refreshToken = useStore(state => state.userInfo.refreshToken);
+    const tokenExpiresAt = useStore(state => state.userInfo.tokenExpiresAt);
+
+    useEffect(() => {
+        const handleStorage = (e: StorageEvent) => {
+            if (e.key === 'client-store') useStore.persist.rehydrate();
+        };
+        window.addEventListener('storage', handleStorage);
+        return () => {
+            window.removeEventListener('storage', handleStorage);
+        };
+    }, []);
+
+    useEffect(() => {
+        if (!refreshToken) return;
+        // 提前1分钟刷新
+        const delay = Math.max((tokenExpiresAt || 0) - Date.now() - 60 * 1000, 0);
+        const timer = setTimeout(() => {
+            const baseHost = `http://${isClient
+                ? `127.0.0.1:${localStorage.getItem("appPort") || "4321"}`
+                : location.host
+            }`;
+            axios.post(`${baseHost}/api/v1/refreshToken`, { refreshToken }).then(res => {
+                if (res.data?.code === 200) {
+                    const data = res.data.data;
+                    useStore.setState(state => ({
+                        userInfo: {
+                            ...state.userInfo,
+                            token: data.token,
+                            refreshToken: data.refreshToken,
+                            tokenExpiresAt: Date.now() + data.expiresIn * 1000,
+                        }
+                    }));
+                }
+            }).catch(err => {
+                if (err?.response?.status === 401) { // 会话已注销或过期，需要重新登录
+                    useStore.setState(state => ({
+                        validExpToken: true,
+                        userInfo: isClient ? state.userInfo : { ...state.userInfo, token: '', refreshToken: '' }
+                    }));
+                }
+            });
+        }, delay);
+        return () => {
+            clearTimeout(timer);
+        };
+    }, [isClient, refreshToken, tokenExpiresAt]);
+}
@@ -35,6 +35,8 @@ type AppStore = {
     role: string,
     avatar: string,
     userPwd: string,
+    refreshToken: string, // 刷新token
+    tokenExpiresAt: number, // 访问token过期时间（毫秒时间戳）
   }, // 用户信息
   validExpToken: boolean, // token是否有效
   uploadedFiles: Record<string, any>, // 上传的文件列表
@@ -135,6 +137,8 @@ const useStore = create<AppStore>()(
         role: "",
         avatar: "",
         userPwd: "",
+        refreshToken: "",
+        tokenExpiresAt: 0,
       },
       validExpToken: false,
       uploadedFiles: {},
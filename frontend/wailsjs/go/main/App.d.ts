@@ -17,7 +17,7 @@ export function ToolsParseToken(arg1:string):Promise<server.UserToken>;
 
 export function ToolsPingHost(arg1:any,arg2:string):Promise<Record<string, any>>;
 
-export function ToolsScanNetwork(arg1:string,arg2:string):Promise<tools.ScanNetworkData>;
+export function ToolsScanNetwork(arg1:string,arg2:string,arg3:string):Promise<tools.ScanNetworkData>;
 
 export function UpdateConfigData(arg1:Record<string, any>):Promise<Record<string, any>>;
 
@@ -30,8 +30,8 @@ export function ToolsPingHost(arg1, arg2) {
   return window['go']['main']['App']['ToolsPingHost'](arg1, arg2);
 }
 
-export function ToolsScanNetwork(arg1, arg2) {
-  return window['go']['main']['App']['ToolsScanNetwork'](arg1, arg2);
+export function ToolsScanNetwork(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ToolsScanNetwork'](arg1, arg2, arg3);
 }
 
 export function UpdateConfigData(arg1) {
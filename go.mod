@@ -8,6 +8,7 @@ require (
 	github.com/panjianxin1996/systray-heighten v1.0.3
 	github.com/shirou/gopsutil/v4 v4.25.4
 	github.com/wailsapp/wails/v2 v2.10.1
+	golang.org/x/crypto v0.37.0
 )
 
 require (
@@ -55,7 +56,6 @@ require (
 	go.opentelemetry.io/otel/trace v1.36.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.27.0 // indirect
-	golang.org/x/crypto v0.37.0 // indirect
 	golang.org/x/mod v0.24.0 // indirect
 	golang.org/x/sync v0.13.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
@@ -4,6 +4,6 @@ echo -e "Start running the script..."
 cd ../
 
 echo -e "Start building the app for macos platform..."
-wails build --clean --platform darwin
+wails build --clean -tags sqlite_fts5 --platform darwin
 
 echo -e "End running the script!"
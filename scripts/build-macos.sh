@@ -4,6 +4,6 @@ echo -e "Start running the script..."
 cd ../
 
 echo -e "Start building the app for macos platform..."
-wails build --clean --platform darwin/universal
+wails build --clean -tags sqlite_fts5 --platform darwin/universal
 
 echo -e "End running the script!"
@@ -4,6 +4,6 @@ echo -e "Start running the script..."
 cd ../
 
 echo -e "Start building the app..."
-wails build --clean
+wails build --clean -tags sqlite_fts5
 
 echo -e "End running the script!"